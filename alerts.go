@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+// alertAny clears rules, so any balance change is notified again
+const alertAny = "any"
+
+// alertKinds in the order they are offered
+var alertKinds = []string{model.AlertTotalAbove, model.AlertNewBill, model.AlertDebtIncrease}
+
+// totalDueRequisites are lowercase parts of requisites summing balance up
+var totalDueRequisites = []string{"к оплате", "итого"}
+
+// totalDue is the amount of the row summing balance up. Without such row the total is unknown:
+// other rows are opening balance, charges, payments and so on, their sum isn't a debt.
+func totalDue(snapshot model.BalanceSnapshot) (float64, bool) {
+	for _, row := range snapshot.Rows {
+		requisite := strings.ToLower(row.Requisite)
+		for _, part := range totalDueRequisites {
+			if strings.Contains(requisite, part) {
+				return row.Amount, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// firedAlerts returns rules triggered by the change from previous to current balance
+func firedAlerts(rules []model.AlertRule, previous model.BalanceSnapshot, current model.BalanceSnapshot) []model.AlertRule {
+	var fired []model.AlertRule
+	newMonth := previous.Month != current.Month
+	oldTotal, oldKnown := totalDue(previous)
+	newTotal, newKnown := totalDue(current)
+	for _, rule := range rules {
+		if alertFires(rule, newMonth, oldTotal, newTotal, oldKnown && newKnown) {
+			fired = append(fired, rule)
+		}
+	}
+	return fired
+}
+
+// alertFires tells whether rule fires, rules on amounts are skipped if totals are unknown
+func alertFires(rule model.AlertRule, newMonth bool, oldTotal float64, newTotal float64, totalsKnown bool) bool {
+	if rule.Kind != model.AlertNewBill && !totalsKnown {
+		return false
+	}
+	switch rule.Kind {
+	case model.AlertTotalAbove:
+		// once total crosses the threshold and then on every new bill above it
+		return newTotal > rule.Threshold && (oldTotal <= rule.Threshold || newMonth)
+	case model.AlertNewBill:
+		return newMonth
+	case model.AlertDebtIncrease:
+		return newTotal-oldTotal > rule.Threshold
+	}
+	return false
+}
+
+// formatFiredAlerts explains why the notification is sent, a new month is already told by the diff
+func formatFiredAlerts(fired []model.AlertRule, previous model.BalanceSnapshot, current model.BalanceSnapshot, tr translator) string {
+	// amount rules fire only if both totals are known
+	oldTotal, _ := totalDue(previous)
+	newTotal, _ := totalDue(current)
+	var sb strings.Builder
+	for _, rule := range fired {
+		switch rule.Kind {
+		case model.AlertTotalAbove:
+			sb.WriteString(tr.text("alert.firedTotalAbove", newTotal, formatAmount(rule.Threshold)) + "\n")
+		case model.AlertDebtIncrease:
+			sb.WriteString(tr.text("alert.firedDebtIncrease", newTotal-oldTotal) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// configureAlerts shows alert rules of a subscription and changes them.
+// "/alert <account> new_bill" toggles the rule, "/alert <account> total_above 5000" sets the threshold,
+// "/alert <account> total_above" removes active rule or asks for the threshold.
+func (h *handler) configureAlerts(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, args []string, accountNum string,
+	tr translator) interface{} {
+	sub, ok := userInfo.Subscriptions[accountNum]
+	if !ok || sub.ChatID == 0 {
+		return replyWithMessage(upd, tr.text("alert.notSubscribed", accountNum))
+	}
+
+	kind, amount := argAt(args, 1), argAt(args, 2)
+	switch {
+	case kind == "":
+		return alertsMenu(upd, accountNum, sub.Alerts, tr)
+	case kind == alertAny:
+		sub.Alerts = nil
+	case amount != "":
+		threshold, _ := parseAmount(amount)
+		sub.Alerts = append(withoutAlert(sub.Alerts, kind), model.AlertRule{Kind: kind, Threshold: threshold})
+	case hasAlert(sub.Alerts, kind) || kind == model.AlertNewBill:
+		sub.Alerts = toggleAlert(sub.Alerts, kind)
+	default:
+		userInfo.Conversation = &model.Conversation{Step: model.StepAlertAmount, Account: accountNum, Alert: kind}
+		if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+			loggerFrom(ctx).errorf("Error while saving user: %v", err)
+			return replyWithMessage(upd, tr.text("error"))
+		}
+		return replyWithMessage(upd, tr.text("alert.askAmount"))
+	}
+
+	userInfo.Subscriptions[accountNum] = sub
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return alertsMenu(upd, accountNum, sub.Alerts, tr)
+}
+
+// continueAlertConversation sets the rule once user sends its threshold
+func (h *handler) continueAlertConversation(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, text string, tr translator) interface{} {
+	if _, err := parseAmount(text); err != nil {
+		return replyWithMessage(upd, tr.text("alert.badAmount"))
+	}
+	conversation := *userInfo.Conversation
+	userInfo.Conversation = nil
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+	}
+	return h.configureAlerts(
+		ctx, upd, userID, userInfo, []string{conversation.Account, conversation.Alert, text}, conversation.Account, tr)
+}
+
+func alertsMenu(upd telegram.Update, accountNum string, rules []model.AlertRule, tr translator) telegram.ReplyMessage {
+	lines := []string{tr.text("alert.menu", accountNum)}
+	if len(rules) == 0 {
+		lines = append(lines, "• "+tr.text("alert.anyChange"))
+	}
+	for _, rule := range rules {
+		lines = append(lines, "• "+describeAlert(rule, tr))
+	}
+	return telegram.ReplyMessage{
+		ChatId:      getReplyToChatID(upd),
+		Text:        strings.Join(lines, "\n"),
+		ReplyMarkup: alertButtons(accountNum, rules, tr),
+	}
+}
+
+// alertButtons toggle every rule, active ones are marked
+func alertButtons(accountNum string, rules []model.AlertRule, tr translator) telegram.InlineKeyboardMarkup {
+	keyboard := make([][]telegram.InlineKeyboardButton, 0, len(alertKinds)+1)
+	for _, kind := range alertKinds {
+		text := "➕ " + alertButtonText(kind, tr)
+		for _, rule := range rules {
+			if rule.Kind == kind {
+				text = "✅ " + describeAlert(rule, tr)
+			}
+		}
+		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+			{Text: text, CallbackData: fmt.Sprintf("/alert %v %v", accountNum, kind)},
+		})
+	}
+	keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+		{Text: tr.text("alert.anyButton"), CallbackData: fmt.Sprintf("/alert %v %v", accountNum, alertAny)},
+	})
+	return telegram.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+func describeAlert(rule model.AlertRule, tr translator) string {
+	switch rule.Kind {
+	case model.AlertTotalAbove:
+		return tr.text("alert.totalAbove", formatAmount(rule.Threshold))
+	case model.AlertNewBill:
+		return tr.text("alert.newBill")
+	case model.AlertDebtIncrease:
+		return tr.text("alert.debtIncrease", formatAmount(rule.Threshold))
+	}
+	return rule.Kind
+}
+
+func alertButtonText(kind string, tr translator) string {
+	switch kind {
+	case model.AlertTotalAbove:
+		return tr.text("alert.totalAboveButton")
+	case model.AlertDebtIncrease:
+		return tr.text("alert.debtIncreaseButton")
+	}
+	return describeAlert(model.AlertRule{Kind: kind}, tr)
+}
+
+func hasAlert(rules []model.AlertRule, kind string) bool {
+	for _, rule := range rules {
+		if rule.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutAlert(rules []model.AlertRule, kind string) []model.AlertRule {
+	result := make([]model.AlertRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Kind != kind {
+			result = append(result, rule)
+		}
+	}
+	return result
+}
+
+func toggleAlert(rules []model.AlertRule, kind string) []model.AlertRule {
+	if hasAlert(rules, kind) {
+		return withoutAlert(rules, kind)
+	}
+	return append(rules, model.AlertRule{Kind: kind})
+}
+
+// ruleWithoutAccount makes "/alert new_bill" mean the rule of the only (or yet to be chosen) account
+func ruleWithoutAccount(args []string) []string {
+	if len(args) > 0 && isAlertKind(args[0]) {
+		return append([]string{""}, args...)
+	}
+	return args
+}
+
+func isAlertKind(arg string) bool {
+	if arg == alertAny {
+		return true
+	}
+	for _, kind := range alertKinds {
+		if arg == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func isAmount(arg string) bool {
+	_, err := parseAmount(arg)
+	return err == nil
+}
+
+// parseAmount accepts positive amounts in rubles, with either decimal separator
+func parseAmount(text string) (float64, error) {
+	amount, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", ".", 1), 64)
+	if err != nil {
+		return 0, err
+	}
+	if !(amount > 0) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("Amount must be positive: %v", text)
+	}
+	return amount, nil
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
+
+func argAt(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
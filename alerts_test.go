@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestTotalDue(t *testing.T) {
+	cases := []struct {
+		snapshot model.BalanceSnapshot
+		expected float64
+		known    bool
+	}{
+		{makeSnapshot("Январь", "Отопление", 100.0, "Итого к оплате", 150.0, "Вода", 50.0), 150, true},
+		{makeSnapshot("Январь", "Отопление", 100.0, "ИТОГО", 120.0), 120, true},
+		{makeSnapshot("Январь", "Входящее сальдо", 100.0, "Начислено", 50.5, "Оплачено", 100.0), 0, false},
+		{makeSnapshot("Январь"), 0, false},
+	}
+	for _, c := range cases {
+		if total, known := totalDue(c.snapshot); total != c.expected || known != c.known {
+			t.Errorf("%v: expected %v %v, but got %v %v", c.snapshot, c.expected, c.known, total, known)
+		}
+	}
+}
+
+func TestFiredAlerts(t *testing.T) {
+	above := model.AlertRule{Kind: model.AlertTotalAbove, Threshold: 5000}
+	newBill := model.AlertRule{Kind: model.AlertNewBill}
+	increase := model.AlertRule{Kind: model.AlertDebtIncrease, Threshold: 1000}
+	cases := []struct {
+		name     string
+		previous model.BalanceSnapshot
+		current  model.BalanceSnapshot
+		fired    []model.AlertRule
+	}{
+		{"crossed threshold", makeSnapshot("Январь", "Итого", 4000.0), makeSnapshot("Январь", "Итого", 5200.0),
+			[]model.AlertRule{above, increase}},
+		{"stays above", makeSnapshot("Январь", "Итого", 5200.0), makeSnapshot("Январь", "Итого", 5300.0), nil},
+		{"new bill above", makeSnapshot("Январь", "Итого", 5200.0), makeSnapshot("Февраль", "Итого", 5300.0),
+			[]model.AlertRule{above, newBill}},
+		{"paid", makeSnapshot("Январь", "Итого", 5200.0), makeSnapshot("Январь", "Итого", 0.0), nil},
+		{"small increase", makeSnapshot("Январь", "Итого", 100.0), makeSnapshot("Январь", "Итого", 1100.0), nil},
+		{"unknown total", makeSnapshot("Январь", "Итого", 100.0), makeSnapshot("Февраль", "Начислено", 9000.0),
+			[]model.AlertRule{newBill}},
+	}
+	for _, c := range cases {
+		fired := firedAlerts([]model.AlertRule{above, newBill, increase}, c.previous, c.current)
+		if len(fired) != len(c.fired) {
+			t.Errorf("%v: expected %v, but got %v", c.name, c.fired, fired)
+			continue
+		}
+		for i := range fired {
+			if fired[i] != c.fired[i] {
+				t.Errorf("%v: expected %v, but got %v", c.name, c.fired, fired)
+			}
+		}
+	}
+}
+
+func TestAlertArgumentsAreValidated(t *testing.T) {
+	if _, err := ParseCommand("/alert account_0 sometimes"); err == nil {
+		t.Error("Expected unknown rule to be rejected")
+	}
+	if _, err := ParseCommand("/alert account_0 total_above -5"); err == nil {
+		t.Error("Expected negative amount to be rejected")
+	}
+	cmd, err := ParseCommand("/alert debt_increase 10 foo")
+	if err != nil || len(cmd.Args) != 3 || cmd.Args[2] != "10" {
+		t.Error("Expected extra argument to be ignored, but got ", cmd, err)
+	}
+	cmd, err = ParseCommand("/alert total_above 5000,50")
+	if err != nil || len(cmd.Args) != 3 || cmd.Args[0] != "" || cmd.Args[1] != model.AlertTotalAbove {
+		t.Error("Expected rule without account, but got ", cmd, err)
+	}
+}
+
+func TestAlertCommandConfiguresRules(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	h := createAlertTestHandler(storage)
+
+	h.handle(context.Background(), makeCallbackUpdate("/alert account_0 new_bill"))
+	reply := h.handle(context.Background(), makeMsgUpdate("/alert total_above 5000,50"))
+
+	expected := []model.AlertRule{
+		{Kind: model.AlertNewBill},
+		{Kind: model.AlertTotalAbove, Threshold: 5000.5},
+	}
+	ensureAlerts(t, storage, expected)
+	msg := reply.(telegram.ReplyMessage)
+	if !strings.Contains(msg.Text, "5000.5") {
+		t.Error("Expected rules in reply, but got ", msg.Text)
+	}
+	buttons := msg.ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+	if len(buttons) != 4 || buttons[0][0].CallbackData != "/alert account_0 total_above" ||
+		!strings.HasPrefix(buttons[0][0].Text, "✅") || !strings.HasPrefix(buttons[2][0].Text, "➕") {
+		t.Error("Unexpected buttons: ", buttons)
+	}
+
+	h.handle(context.Background(), makeCallbackUpdate("/alert account_0 new_bill"))
+	ensureAlerts(t, storage, expected[1:])
+
+	h.handle(context.Background(), makeCallbackUpdate("/alert account_0 any"))
+	ensureAlerts(t, storage, nil)
+}
+
+func TestAlertAsksForThreshold(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	h := createAlertTestHandler(storage)
+
+	h.handle(context.Background(), makeCallbackUpdate("/alert account_0 debt_increase"))
+	if storage.userInfo.Conversation == nil || storage.userInfo.Conversation.Step != model.StepAlertAmount {
+		t.Fatal("Expected conversation, but got ", storage.userInfo.Conversation)
+	}
+	h.handle(context.Background(), makeMsgUpdate("много"))
+	h.handle(context.Background(), makeMsgUpdate("1000"))
+
+	ensureAlerts(t, storage, []model.AlertRule{{Kind: model.AlertDebtIncrease, Threshold: 1000}})
+	if storage.userInfo.Conversation != nil {
+		t.Error("Conversation must be finished")
+	}
+}
+
+func TestAlertRequiresSubscription(t *testing.T) {
+	storage := createFakeStorageCapturingWrites(func(int, model.UserInfo) {
+		t.Error("User must not be written")
+	})
+	h := createAlertTestHandler(storage)
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/alert account_0 new_bill"))
+
+	if msg := reply.(telegram.ReplyMessage); !strings.Contains(msg.Text, "/notify account_0") {
+		t.Error("Expected suggestion to subscribe, but got ", msg.Text)
+	}
+}
+
+func TestNotifyKeepsAlerts(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	rules := []model.AlertRule{{Kind: model.AlertNewBill}}
+	storage.userInfo.Subscriptions["account_0"] = model.SubscriptionInfo{ChatID: chatID, Alerts: rules}
+	h := createAlertTestHandler(storage)
+
+	h.handle(context.Background(), makeMsgUpdate("/notify account_0"))
+
+	ensureAlerts(t, storage, rules)
+}
+
+func TestNotifierSuppressesChangesNotMatchingAlerts(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Subscriptions["account_0"] = model.SubscriptionInfo{
+		ChatID:   chatID,
+		LastSeen: &snapshot,
+		Alerts:   []model.AlertRule{{Kind: model.AlertTotalAbove, Threshold: 5000}},
+	}
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 250), sender)
+
+	n.runCycle(context.Background())
+
+	ensureNoMessages(t, sender)
+	if storage.userInfo.Subscriptions["account_0"].LastSeen.Rows[0].Amount != 250 {
+		t.Error("New balance must be saved")
+	}
+
+	n = createTestNotifier(storage, createBalanceClient("Январь", 5250), sender)
+	n.runCycle(context.Background())
+
+	if len(sender.messages) != 1 || !strings.Contains(sender.messages[0].Text, "5250.00") {
+		t.Error("Expected alert, but got ", sender.messages)
+	}
+}
+
+func createAlertTestHandler(storage *fakeStorage) handler {
+	return createHandler(storage, newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+}
+
+func ensureAlerts(t *testing.T, storage *fakeStorage, expected []model.AlertRule) {
+	t.Helper()
+	alerts := storage.userInfo.Subscriptions["account_0"].Alerts
+	if len(alerts) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, alerts)
+	}
+	for i := range alerts {
+		if alerts[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, alerts)
+		}
+	}
+}
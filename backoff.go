@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+// backoffPolicy defines how long to wait after failed checks
+type backoffPolicy struct {
+	base              time.Duration
+	max               time.Duration
+	authFailuresLimit int
+}
+
+// delay doubles with every consecutive failure up to max
+func (p backoffPolicy) delay(consecutiveFailures int) time.Duration {
+	delay := p.base
+	for i := 1; i < consecutiveFailures && delay < p.max; i++ {
+		delay *= 2
+	}
+	if delay > p.max {
+		delay = p.max
+	}
+	return delay
+}
+
+// isAuthError tells whether ERC might have rejected credentials.
+// erclib reports any failed login as "Authentication error", network errors included,
+// so checks are paused only after authFailuresLimit of them in a row.
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Authentication error")
+}
+
+// registerFailure persists failed check, postpones next one and
+// pauses checks asking user to re-register if credentials keep failing
+func (n notifier) registerFailure(ctx context.Context, userID int, err error) {
+	var health model.CheckHealth
+	var userInfo model.UserInfo
+	saveErr := n.updateUser(ctx, userID, func(stored *model.UserInfo) bool {
+		if stored.Health != nil {
+			health = *stored.Health
+		}
+		health.ConsecutiveFailures++
+		if isAuthError(err) {
+			health.AuthFailures++
+		} else {
+			health.AuthFailures = 0
+		}
+		health.NextAttempt = n.now().Add(n.backoff.delay(health.ConsecutiveFailures))
+		if health.AuthFailures >= n.backoff.authFailuresLimit {
+			health.Paused = true
+		}
+		stored.Health = &health
+		userInfo = *stored
+		return true
+	})
+	if saveErr != nil {
+		loggerFrom(ctx).errorf("Unable to save check health: %v", saveErr)
+	}
+	if userInfo.Login == "" {
+		return
+	}
+	if health.Paused {
+		loggerFrom(ctx).warnf("Credentials failed %v times. Pause.", health.AuthFailures)
+		n.askToReRegister(ctx, userInfo)
+	} else {
+		loggerFrom(ctx).warnf("Check failed %v times, next attempt at %v",
+			health.ConsecutiveFailures, health.NextAttempt)
+	}
+}
+
+func (n notifier) askToReRegister(ctx context.Context, userInfo model.UserInfo) {
+	tr := newTranslator(userInfo.Language)
+	notified := make(map[int]bool)
+	for _, sub := range userInfo.Subscriptions {
+		if sub.ChatID == 0 || notified[sub.ChatID] {
+			continue
+		}
+		notified[sub.ChatID] = true
+		err := n.sender.SendMessage(ctx, telegram.ReplyMessage{
+			ChatId: sub.ChatID,
+			Text:   tr.text("notify.reRegister"),
+		})
+		if err != nil {
+			loggerFrom(ctx).errorf("Unable to ask to re-register: %v", err)
+		}
+	}
+}
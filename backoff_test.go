@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minya/ercInfoBot/model"
+)
+
+func TestBackoffDelayDoublesUpToMax(t *testing.T) {
+	policy := backoffPolicy{base: time.Hour, max: 5 * time.Hour}
+	expected := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 5 * time.Hour, 5 * time.Hour}
+	for i, delay := range expected {
+		if got := policy.delay(i + 1); got != delay {
+			t.Errorf("Failure #%v: expected %v, but got %v", i+1, delay, got)
+		}
+	}
+}
+
+func TestNotifierBacksOffAfterTransientFailure(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	client := createBalanceClient("Январь", 100)
+	client.accountsErr = fmt.Errorf("connection refused")
+	sender := &fakeSender{}
+	clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	built := 0
+	n := createBackoffNotifier(storage, &built, &client, sender, clock)
+
+	n.runCycle(context.Background())
+
+	health := storage.userInfo.Health
+	if health == nil || health.ConsecutiveFailures != 1 || health.AuthFailures != 0 || health.Paused {
+		t.Fatal("Unexpected health: ", health)
+	}
+	if !health.NextAttempt.Equal(clock.current.Add(time.Hour)) {
+		t.Error("Unexpected next attempt: ", health.NextAttempt)
+	}
+
+	clock.advance(30 * time.Minute)
+	n.runCycle(context.Background())
+	if built != 1 {
+		t.Error("User must not be checked before next attempt")
+	}
+
+	clock.advance(30 * time.Minute)
+	n.runCycle(context.Background())
+	if built != 2 || storage.userInfo.Health.ConsecutiveFailures != 2 {
+		t.Error("User must be checked again after backoff: ", storage.userInfo.Health)
+	}
+	if !storage.userInfo.Health.NextAttempt.Equal(clock.current.Add(2 * time.Hour)) {
+		t.Error("Backoff must double: ", storage.userInfo.Health.NextAttempt)
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierResetsHealthOnSuccess(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	storage.userInfo.Health = &model.CheckHealth{ConsecutiveFailures: 2, AuthFailures: 2}
+	client := createBalanceClient("Январь", 100)
+	built := 0
+	n := createBackoffNotifier(storage, &built, &client, &fakeSender{}, &fakeClock{current: time.Now()})
+
+	n.runCycle(context.Background())
+
+	if storage.userInfo.Health != nil {
+		t.Error("Health must be reset, but got ", storage.userInfo.Health)
+	}
+	if storage.userInfo.Subscriptions["account_0"].LastSeen == nil {
+		t.Error("Balance must be checked")
+	}
+}
+
+func TestNotifierPausesAfterAuthFailures(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	storage.userInfo.Subscriptions["account_1"] = model.SubscriptionInfo{ChatID: chatID}
+	client := createBalanceClient("Январь", 100)
+	client.accountsErr = fmt.Errorf("Authentication error")
+	sender := &fakeSender{}
+	clock := &fakeClock{current: time.Now()}
+	built := 0
+	n := createBackoffNotifier(storage, &built, &client, sender, clock)
+
+	for i := 0; i < defaultAuthFailuresLimit; i++ {
+		n.runCycle(context.Background())
+		clock.advance(defaultMaxBackoff)
+	}
+
+	if !storage.userInfo.Health.Paused {
+		t.Fatal("Checks must be paused: ", storage.userInfo.Health)
+	}
+	if len(sender.messages) != 1 || !strings.Contains(sender.messages[0].Text, "/reg") {
+		t.Fatal("Expected one message asking to re-register, but got ", sender.messages)
+	}
+
+	n.runCycle(context.Background())
+	if built != defaultAuthFailuresLimit || len(sender.messages) != 1 {
+		t.Error("Paused user must not be checked nor notified again")
+	}
+}
+
+func TestNotifierDoesNotPauseBeforeAuthFailuresLimit(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	client := createBalanceClient("Январь", 100)
+	client.accountsErr = fmt.Errorf("Authentication error")
+	sender := &fakeSender{}
+	clock := &fakeClock{current: time.Now()}
+	built := 0
+	n := createBackoffNotifier(storage, &built, &client, sender, clock)
+
+	for i := 0; i < defaultAuthFailuresLimit-1; i++ {
+		n.runCycle(context.Background())
+		clock.advance(defaultMaxBackoff)
+	}
+
+	health := storage.userInfo.Health
+	if health.Paused || health.AuthFailures != defaultAuthFailuresLimit-1 {
+		t.Error("Failed login must be backed off only, but got ", health)
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierTransientFailureResetsAuthFailures(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	storage.userInfo.Health = &model.CheckHealth{ConsecutiveFailures: 2, AuthFailures: 2}
+	client := createBalanceClient("Январь", 100)
+	client.accountsErr = errCallTimeout
+	built := 0
+	n := createBackoffNotifier(storage, &built, &client, &fakeSender{}, &fakeClock{current: time.Now()})
+
+	n.runCycle(context.Background())
+
+	health := storage.userInfo.Health
+	if health.AuthFailures != 0 || health.ConsecutiveFailures != 3 || health.Paused {
+		t.Error("Unexpected health: ", health)
+	}
+}
+
+func TestRegisterResumesPausedChecks(t *testing.T) {
+	storage := createFakeStorage()
+	storage.userInfo.Health = &model.CheckHealth{AuthFailures: 3, Paused: true}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	h.handle(context.Background(), makeMsgUpdate("/reg login@gmail.com password"))
+
+	if storage.userInfo.Health != nil {
+		t.Error("Health must be reset after /reg, but got ", storage.userInfo.Health)
+	}
+}
+
+func createBackoffNotifier(storage model.UserStorage, built *int, client *fakeERCClient, sender messageSender, clock *fakeClock) notifier {
+	var makeClient = func(l string, p string) ercclient {
+		*built++
+		return *client
+	}
+	n := createNotifier(storage, newFakeHistory(), makeClient, sender, time.Hour, NotifierSettings{})
+	n.now = clock.now
+	return n
+}
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
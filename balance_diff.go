@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+)
+
+// amounts differing less than this are considered equal
+const amountEpsilon = 0.005
+
+// rowChange describes how a single balance row changed
+type rowChange struct {
+	Requisite string
+	Old       float64
+	New       float64
+	Added     bool
+	Removed   bool
+}
+
+// Delta is the signed amount change
+func (c rowChange) Delta() float64 {
+	return c.New - c.Old
+}
+
+// balanceDiff is the difference between two balance snapshots
+type balanceDiff struct {
+	OldMonth string
+	NewMonth string
+	Changes  []rowChange
+}
+
+// MonthChanged reports whether a new billing month started
+func (d balanceDiff) MonthChanged() bool {
+	return d.OldMonth != d.NewMonth
+}
+
+// IsEmpty reports whether balances are the same
+func (d balanceDiff) IsEmpty() bool {
+	return !d.MonthChanged() && len(d.Changes) == 0
+}
+
+func snapshotBalance(balance erclib.BalanceInfo) model.BalanceSnapshot {
+	rows := make([]model.BalanceRow, 0, len(balance.Rows))
+	for _, row := range balance.Rows {
+		rows = append(rows, model.BalanceRow{Requisite: row.Requisite, Amount: row.Amount})
+	}
+	return model.BalanceSnapshot{Month: balance.Month, Rows: rows}
+}
+
+// diffBalance compares rows by requisite, repeated requisites are matched in order of appearance
+func diffBalance(previous model.BalanceSnapshot, current model.BalanceSnapshot) balanceDiff {
+	diff := balanceDiff{OldMonth: previous.Month, NewMonth: current.Month}
+
+	oldRows := make(map[string][]float64)
+	for _, row := range previous.Rows {
+		oldRows[row.Requisite] = append(oldRows[row.Requisite], row.Amount)
+	}
+
+	for _, row := range current.Rows {
+		amounts := oldRows[row.Requisite]
+		if len(amounts) == 0 {
+			diff.Changes = append(diff.Changes, rowChange{Requisite: row.Requisite, New: row.Amount, Added: true})
+			continue
+		}
+		oldAmount := amounts[0]
+		oldRows[row.Requisite] = amounts[1:]
+		if math.Abs(row.Amount-oldAmount) >= amountEpsilon {
+			diff.Changes = append(diff.Changes, rowChange{Requisite: row.Requisite, Old: oldAmount, New: row.Amount})
+		}
+	}
+
+	for _, row := range previous.Rows {
+		amounts := oldRows[row.Requisite]
+		if len(amounts) == 0 {
+			continue
+		}
+		oldRows[row.Requisite] = amounts[1:]
+		diff.Changes = append(diff.Changes, rowChange{Requisite: row.Requisite, Old: amounts[0], Removed: true})
+	}
+
+	return diff
+}
+
+func formatDiff(account erclib.Account, diff balanceDiff, balance erclib.BalanceInfo, tr translator) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%v\n%v:\n", tr.text("diff.header"), account.Address))
+	if diff.MonthChanged() {
+		sb.WriteString(tr.text("diff.newMonth", diff.NewMonth) + "\n")
+		sb.WriteString(formatRequisites(balance.Rows))
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("%v\n", diff.NewMonth))
+	for _, change := range diff.Changes {
+		switch {
+		case change.Added:
+			sb.WriteString(tr.text("diff.added", change.Requisite, change.New) + "\n")
+		case change.Removed:
+			sb.WriteString(tr.text("diff.removed", change.Requisite, change.Old) + "\n")
+		default:
+			sb.WriteString(fmt.Sprintf("%v: %v → %v (%+.2f)\n",
+				change.Requisite, change.Old, change.New, change.Delta()))
+		}
+	}
+	return sb.String()
+}
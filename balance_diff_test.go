@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+)
+
+func TestDiffBalanceSameIsEmpty(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Долг", 100.0, "Итого", 200.0)
+	diff := diffBalance(snapshot, makeSnapshot("Январь", "Долг", 100.001, "Итого", 200.0))
+	if !diff.IsEmpty() {
+		t.Error("Expected empty diff, but got ", diff)
+	}
+}
+
+func TestDiffBalanceReportsChangedRows(t *testing.T) {
+	diff := diffBalance(
+		makeSnapshot("Январь", "Долг", 100.0, "Итого", 200.0),
+		makeSnapshot("Январь", "Долг", 100.0, "Итого", 150.5))
+
+	if diff.MonthChanged() {
+		t.Error("Month must not change")
+	}
+	if len(diff.Changes) != 1 {
+		t.Fatal("Expected 1 change, but got ", diff.Changes)
+	}
+	change := diff.Changes[0]
+	if change.Requisite != "Итого" || change.Old != 200.0 || change.New != 150.5 || change.Delta() != -49.5 {
+		t.Error("Unexpected change: ", change)
+	}
+}
+
+func TestDiffBalanceReportsNewMonth(t *testing.T) {
+	diff := diffBalance(
+		makeSnapshot("Январь", "Итого", 200.0),
+		makeSnapshot("Февраль", "Итого", 200.0))
+	if !diff.MonthChanged() || diff.IsEmpty() {
+		t.Error("Expected month change, but got ", diff)
+	}
+}
+
+func TestDiffBalanceReportsAddedAndRemovedRows(t *testing.T) {
+	diff := diffBalance(
+		makeSnapshot("Январь", "Пени", 10.0, "Итого", 200.0),
+		makeSnapshot("Январь", "Итого", 200.0, "Капремонт", 30.0))
+
+	if len(diff.Changes) != 2 {
+		t.Fatal("Expected 2 changes, but got ", diff.Changes)
+	}
+	if !diff.Changes[0].Added || diff.Changes[0].Requisite != "Капремонт" || diff.Changes[0].New != 30.0 {
+		t.Error("Expected added row, but got ", diff.Changes[0])
+	}
+	if !diff.Changes[1].Removed || diff.Changes[1].Requisite != "Пени" || diff.Changes[1].Old != 10.0 {
+		t.Error("Expected removed row, but got ", diff.Changes[1])
+	}
+}
+
+func TestDiffBalanceMatchesRepeatedRequisitesInOrder(t *testing.T) {
+	diff := diffBalance(
+		makeSnapshot("Январь", "Начислено", 10.0, "Начислено", 20.0),
+		makeSnapshot("Январь", "Начислено", 10.0, "Начислено", 25.0))
+
+	if len(diff.Changes) != 1 || diff.Changes[0].Old != 20.0 || diff.Changes[0].New != 25.0 {
+		t.Error("Unexpected changes: ", diff.Changes)
+	}
+}
+
+func TestFormatDiffShowsDelta(t *testing.T) {
+	account := erclib.Account{Number: "account_0", Address: "Address 0"}
+	balance := erclib.BalanceInfo{Month: "Январь", Rows: []erclib.BalanceRow{{Requisite: "Итого", Amount: 150.5}}}
+	diff := diffBalance(makeSnapshot("Январь", "Итого", 200.0), snapshotBalance(balance))
+
+	text := formatDiff(account, diff, balance, newTranslator(langRU))
+
+	if !strings.Contains(text, "Address 0") || !strings.Contains(text, "Итого: 200 → 150.5 (-49.50)") {
+		t.Error("Unexpected text: ", text)
+	}
+}
+
+func TestFormatDiffShowsWholeBalanceForNewMonth(t *testing.T) {
+	account := erclib.Account{Number: "account_0", Address: "Address 0"}
+	balance := erclib.BalanceInfo{Month: "Февраль", Rows: []erclib.BalanceRow{{Requisite: "Итого", Amount: 200}}}
+	diff := diffBalance(makeSnapshot("Январь", "Итого", 200.0), snapshotBalance(balance))
+
+	text := formatDiff(account, diff, balance, newTranslator(langRU))
+
+	if !strings.Contains(text, "Новый расчетный период: Февраль") || !strings.Contains(text, "Итого: 200") {
+		t.Error("Unexpected text: ", text)
+	}
+}
+
+// makeSnapshot builds snapshot from requisite/amount pairs
+func makeSnapshot(month string, rows ...interface{}) model.BalanceSnapshot {
+	snapshot := model.BalanceSnapshot{Month: month}
+	for i := 0; i < len(rows); i += 2 {
+		snapshot.Rows = append(snapshot.Rows, model.BalanceRow{
+			Requisite: rows[i].(string),
+			Amount:    rows[i+1].(float64),
+		})
+	}
+	return snapshot
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minya/goutils/web"
+	"github.com/minya/telegram"
+)
+
+const telegramAPIURL = "https://api.telegram.org"
+
+// botAPI calls Bot API methods which github.com/minya/telegram doesn't provide
+type botAPI struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+type botAPIResponse struct {
+	Ok          bool            `json:"ok"`
+	Description string          `json:"description,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+}
+
+func newBotAPI(token string) *botAPI {
+	return &botAPI{
+		token:   token,
+		baseURL: telegramAPIURL,
+		client:  &http.Client{Transport: web.DefaultTransport(1000)},
+	}
+}
+
+// DeleteMessage removes message from chat
+func (api *botAPI) DeleteMessage(ctx context.Context, chatID int, messageID int) error {
+	type deleteMessageParams struct {
+		ChatID    int `json:"chat_id"`
+		MessageID int `json:"message_id"`
+	}
+	_, err := api.callMethod(ctx, "deleteMessage", deleteMessageParams{ChatID: chatID, MessageID: messageID})
+	return err
+}
+
+// SendMessage sends text message
+func (api *botAPI) SendMessage(ctx context.Context, msg telegram.ReplyMessage) error {
+	loggerFrom(ctx).debugf("Sending msg to %v", msg.ChatId)
+	_, err := api.callMethod(ctx, "sendMessage", msg)
+	return err
+}
+
+// SendDocument uploads document to chat
+func (api *botAPI) SendDocument(ctx context.Context, document telegram.ReplyDocument) error {
+	loggerFrom(ctx).debugf("Sending document to %v", document.ChatId)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, err := writer.CreateFormFile("document", document.InputFile.FileName)
+	if err != nil {
+		return err
+	}
+	if _, err = fileWriter.Write(document.InputFile.Content); err != nil {
+		return err
+	}
+	writer.WriteField("chat_id", strconv.Itoa(document.ChatId))
+	writer.WriteField("caption", document.Caption)
+	if document.ReplyMarkup != nil {
+		markup, err := json.Marshal(document.ReplyMarkup)
+		if err != nil {
+			return err
+		}
+		writer.WriteField("reply_markup", string(markup))
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	_, err = api.post(ctx, "sendDocument", writer.FormDataContentType(), &body)
+	return err
+}
+
+// GetUpdates waits up to timeout for updates starting from offset
+func (api *botAPI) GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]telegram.Update, error) {
+	type getUpdatesParams struct {
+		Offset         int      `json:"offset"`
+		Timeout        int      `json:"timeout"`
+		AllowedUpdates []string `json:"allowed_updates"`
+	}
+	result, err := api.callMethod(ctx, "getUpdates", getUpdatesParams{
+		Offset:         offset,
+		Timeout:        int(timeout / time.Second),
+		AllowedUpdates: []string{"message", "callback_query"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var updates []telegram.Update
+	if err = json.Unmarshal(result, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// DeleteWebhook switches bot to getUpdates, Telegram refuses to serve getUpdates while webhook is set
+func (api *botAPI) DeleteWebhook(ctx context.Context) error {
+	_, err := api.callMethod(ctx, "deleteWebhook", struct{}{})
+	return err
+}
+
+func (api *botAPI) callMethod(ctx context.Context, methodName string, params interface{}) (json.RawMessage, error) {
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return api.post(ctx, methodName, "application/json", bytes.NewReader(paramsBytes))
+}
+
+func (api *botAPI) post(ctx context.Context, methodName string, contentType string, body io.Reader) (json.RawMessage, error) {
+	url := fmt.Sprintf("%v/bot%v/%v", api.baseURL, api.token, methodName)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", contentType)
+	response, err := api.client.Do(request)
+	if err != nil {
+		telegramRequests.inc(methodName, outcomeError)
+		return nil, err
+	}
+	result, err := readBotAPIResponse(methodName, response)
+	telegramRequests.inc(methodName, outcomeOf(err))
+	return result, err
+}
+
+func readBotAPIResponse(methodName string, response *http.Response) (json.RawMessage, error) {
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result botAPIResponse
+	if err = json.Unmarshal(responseBytes, &result); err != nil {
+		return nil, fmt.Errorf("%v: %v from telegram API", methodName, response.StatusCode)
+	}
+	if !result.Ok {
+		return nil, fmt.Errorf("%v: %v", methodName, result.Description)
+	}
+	return result.Result, nil
+}
@@ -1,49 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"regexp"
+	"strings"
+	"unicode"
 )
 
-// ParseCommand receives telegram cmd string and produces Command structure
-func ParseCommand(cmdStr string) (Command, error) {
-	reCommand, _ := regexp.Compile("(/?[\\w\\.;@,!@#$&^-_=*\\+]+)")
-	match := reCommand.FindAllStringSubmatch(cmdStr, -1)
-
-	cmd := Command{}
-	if len(match) == 0 {
-		return cmd, fmt.Errorf("Unknown command: %v", cmdStr)
-	}
-
-	cmd.Command = match[0][0]
-	switch cmd.Command {
-	case "/reg":
-		cmd.Args = make([]string, 2, 2)
-		cmd.Args[0] = match[1][0]
-		cmd.Args[1] = match[2][0]
-	case "/receipt":
-		cmd.Args = make([]string, 0, 1)
-		if len(match) > 1 {
-			cmd.Args = append(cmd.Args, match[1][0])
-		}
-	case "/notify":
-		cmd.Args = make([]string, 0, 1)
-		if len(match) > 1 {
-			cmd.Args = append(cmd.Args, match[1][0])
-		}
-	case "/get":
-		cmd.Args = make([]string, 0, 1)
-		if len(match) > 1 {
-			cmd.Args = append(cmd.Args, match[1][0])
-		}
-	case "/help":
-		cmd.Args = make([]string, 0, 0)
-	default:
-		return cmd, fmt.Errorf("Unknown command: %v", cmd.Command)
-	}
-
-	return cmd, nil
-}
+var (
+	errUnterminatedQuote  = errors.New("Unterminated quote")
+	errUnterminatedEscape = errors.New("Nothing to escape at the end")
+)
 
 // Command structure:
 // Command - name of command (/reg, /help, etc)
@@ -52,3 +19,109 @@ type Command struct {
 	Command string
 	Args    []string
 }
+
+// usageError tells that an argument doesn't match the command's spec
+type usageError struct {
+	spec commandSpec
+	arg  int
+}
+
+func (e usageError) Error() string {
+	return e.text(newTranslator(defaultLanguage))
+}
+
+// text explains the error to user
+func (e usageError) text(tr translator) string {
+	return tr.text(e.spec.args[e.arg].invalid) + "\n" + tr.text("usage", e.spec.usage(tr))
+}
+
+// ParseCommand receives telegram cmd string and produces Command structure.
+// Arguments are parsed and validated by the command's spec from the registry,
+// arguments beyond the spec are ignored.
+func ParseCommand(cmdStr string) (Command, error) {
+	tokens, err := tokenize(cmdStr)
+	if err != nil {
+		return Command{}, err
+	}
+	if len(tokens) == 0 {
+		return Command{}, fmt.Errorf("Empty command")
+	}
+
+	cmd := Command{Command: commandName(tokens[0])}
+	spec, ok := findCommand(cmd.Command)
+	if !ok {
+		return cmd, fmt.Errorf("Unknown command: %v", cmd.Command)
+	}
+
+	// normalize may insert arguments, so extra ones are cut after it to keep args within the spec
+	args := append([]string{}, tokens[1:]...)
+	if spec.normalize != nil {
+		args = spec.normalize(args)
+	}
+	if len(args) > len(spec.args) {
+		args = args[:len(spec.args)]
+	}
+	cmd.Args = args
+	for i, arg := range cmd.Args {
+		if valid := spec.args[i].valid; valid != nil && arg != "" && !valid(arg) {
+			return cmd, usageError{spec: spec, arg: i}
+		}
+	}
+	return cmd, nil
+}
+
+// commandName strips bot mention Telegram adds in groups: /get@ErcInfoBot is /get
+func commandName(token string) string {
+	if i := strings.IndexRune(token, '@'); i > 0 {
+		return token[:i]
+	}
+	return token
+}
+
+// tokenize splits text into whitespace separated tokens.
+// Double or single quotes make one token of several words, e.g. a password with spaces.
+// Backslash escapes the next character everywhere except inside single quotes.
+func tokenize(text string) ([]string, error) {
+	var tokens []string
+	var current strings.Builder
+	inToken, escaped := false, false
+	var quote rune
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inToken = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inToken = r, true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errUnterminatedQuote
+	}
+	if escaped {
+		return nil, errUnterminatedEscape
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+// commandAccess tells what handler checks and resolves before running a command
+type commandAccess int
+
+const (
+	// accessAnyone commands work without registration
+	accessAnyone commandAccess = iota
+	// accessAccount commands require connected personal cabinet and an account:
+	// the first argument or the one user chooses
+	accessAccount
+	// accessSubscription commands are like accessAccount ones, but an account given as the first argument
+	// is taken from subscriptions without asking ERC, so they work while ERC is unavailable
+	accessSubscription
+)
+
+// argSpec describes a positional argument, every argument is optional
+type argSpec struct {
+	// name is a message key of argument's name shown in usage
+	name string
+	// secret arguments never reach logs
+	secret bool
+	// internal arguments are sent by buttons and aren't shown in usage
+	internal bool
+	// valid checks non-empty argument, nil accepts anything
+	valid func(string) bool
+	// invalid is a message key explaining what valid expects
+	invalid string
+}
+
+// commandSpec declares a command: its arguments, /help line and handler
+type commandSpec struct {
+	name string
+	args []argSpec
+	// normalize adjusts parsed arguments, e.g. tells "/history 3" from "/history <account>"
+	normalize func(args []string) []string
+	// help is a message key describing command in /help, commands without it are hidden
+	help   string
+	access commandAccess
+	run    func(h *handler, req commandRequest) interface{}
+}
+
+// commandRequest is what a command handler gets
+type commandRequest struct {
+	ctx      context.Context
+	upd      telegram.Update
+	userID   int
+	userInfo model.UserInfo
+	args     []string
+	tr       translator
+	// ercClient and account are set for accessAccount commands,
+	// for accessSubscription ones account may have only its number
+	ercClient ercclient
+	account   erclib.Account
+}
+
+// commands is the registry driving parsing, validation, /help and dispatching.
+// It's filled in init since /help refers to the registry itself.
+var commands []commandSpec
+
+func init() {
+	accountArg := argSpec{name: "arg.account"}
+	commands = []commandSpec{
+		{
+			name:      "/reg",
+			args:      []argSpec{{name: "arg.login"}, {name: "arg.password", secret: true}},
+			normalize: allOrNothing,
+			help:      "help.reg",
+			access:    accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				if len(req.args) < 2 {
+					return h.startRegistration(req.ctx, req.upd, req.userID, req.userInfo, req.tr)
+				}
+				h.deleteMessage(req.ctx, req.upd)
+				return h.register(req.ctx, req.upd, req.userID, req.userInfo, req.args[0], req.args[1], req.tr)
+			},
+		},
+		{
+			name:   "/receipt",
+			args:   []argSpec{accountArg},
+			help:   "help.receipt",
+			access: accessAccount,
+			run: func(h *handler, req commandRequest) interface{} {
+				return receipt(req.ctx, req.upd, req.ercClient, req.account, req.tr)
+			},
+		},
+		{
+			name:   "/get",
+			args:   []argSpec{accountArg},
+			help:   "help.get",
+			access: accessAccount,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.get(req.ctx, req.upd, req.userID, req.ercClient, req.account)
+			},
+		},
+		{
+			name:      "/history",
+			args:      []argSpec{accountArg, {name: "arg.months", valid: isPositiveNumber, invalid: "history.badMonths"}},
+			normalize: monthsWithoutAccount,
+			help:      "help.history",
+			access:    accessAccount,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.showHistory(req.ctx, req.upd, req.userID, req.account, req.args, req.tr)
+			},
+		},
+		{
+			name:   "/notify",
+			args:   []argSpec{accountArg},
+			help:   "help.notify",
+			access: accessAccount,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.setUpNotification(req.ctx, req.upd, req.ercClient, req.account, req.tr)
+			},
+		},
+		{
+			name:   "/unsubscribe",
+			args:   []argSpec{accountArg},
+			help:   "help.unsubscribe",
+			access: accessSubscription,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.unsubscribe(req.ctx, req.upd, req.account, req.tr)
+			},
+		},
+		{
+			name: "/alert",
+			args: []argSpec{
+				accountArg,
+				{name: "arg.rule", valid: isAlertKind, invalid: "alert.badRule"},
+				{name: "arg.amount", valid: isAmount, invalid: "alert.badAmount"},
+			},
+			normalize: ruleWithoutAccount,
+			help:      "help.alert",
+			access:    accessAccount,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.configureAlerts(req.ctx, req.upd, req.userID, req.userInfo, req.args, req.account.Number, req.tr)
+			},
+		},
+		{
+			name: "/remind",
+			args: []argSpec{
+				{name: "arg.dueDay", valid: isDueDay, invalid: "remind.badDueDay"},
+				{name: "arg.daysBefore", valid: isDaysBefore, invalid: "remind.badDaysBefore"},
+			},
+			help:   "help.remind",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.configureReminder(req.ctx, req.upd, req.userID, req.userInfo, req.args, req.tr)
+			},
+		},
+		{
+			name:   "/timezone",
+			args:   []argSpec{{name: "arg.timeZone", valid: isTimeZone, invalid: "timezone.unsupported"}},
+			help:   "help.timezone",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.configureTimeZone(req.ctx, req.upd, req.userID, req.userInfo, req.args, req.tr)
+			},
+		},
+		{
+			name: "/quiet",
+			args: []argSpec{
+				{name: "arg.from", valid: isQuietStart, invalid: "quiet.badStart"},
+				{name: "arg.to", valid: isHour, invalid: "quiet.badHour"},
+			},
+			help:   "help.quiet",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.configureQuietHours(req.ctx, req.upd, req.userID, req.userInfo, req.args, req.tr)
+			},
+		},
+		{
+			name:   "/cancel",
+			help:   "help.cancel",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.cancelConversation(req.ctx, req.upd, req.userID, req.userInfo, req.tr)
+			},
+		},
+		{
+			name:   "/logout",
+			args:   []argSpec{{name: "arg.action", internal: true}},
+			help:   "help.logout",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.logout(req.ctx, req.upd, req.userID, req.args, req.tr)
+			},
+		},
+		{
+			name:   "/lang",
+			args:   []argSpec{{name: "arg.language", valid: isSupportedLanguage, invalid: "lang.unsupported"}},
+			help:   "help.lang",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return h.setLanguage(req.ctx, req.upd, req.userID, req.userInfo, req.args, req.tr)
+			},
+		},
+		{
+			name:   "/help",
+			access: accessAnyone,
+			run: func(h *handler, req commandRequest) interface{} {
+				return help(req.upd, req.tr)
+			},
+		},
+	}
+}
+
+func findCommand(name string) (commandSpec, bool) {
+	for _, spec := range commands {
+		if spec.name == name {
+			return spec, true
+		}
+	}
+	return commandSpec{}, false
+}
+
+// usage shows command with its arguments, e.g. "/history [лицевой счет] [месяцев]"
+func (spec commandSpec) usage(tr translator) string {
+	parts := []string{spec.name}
+	for _, arg := range spec.args {
+		if !arg.internal {
+			parts = append(parts, "["+tr.text(arg.name)+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func isSecretArg(cmd string, pos int) bool {
+	spec, ok := findCommand(cmd)
+	return ok && pos < len(spec.args) && spec.args[pos].secret
+}
+
+func hasSecretArgs(cmd string) bool {
+	spec, _ := findCommand(cmd)
+	for _, arg := range spec.args {
+		if arg.secret {
+			return true
+		}
+	}
+	return false
+}
+
+// helpText lists commands from the registry
+func helpText(tr translator) string {
+	lines := make([]string, 0, len(commands))
+	for _, spec := range commands {
+		if spec.help != "" {
+			lines = append(lines, spec.usage(tr)+" – "+tr.text(spec.help))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// allOrNothing drops incomplete arguments, e.g. /reg with login only starts the wizard
+func allOrNothing(args []string) []string {
+	if len(args) < 2 {
+		return args[:0]
+	}
+	return args
+}
+
+// monthsWithoutAccount makes "/history 3" mean 3 months of the only (or yet to be chosen) account
+func monthsWithoutAccount(args []string) []string {
+	if len(args) == 1 && isMonthsCount(args[0]) {
+		return []string{"", args[0]}
+	}
+	return args
+}
+
+func isMonthsCount(arg string) bool {
+	months, err := strconv.Atoi(arg)
+	return err == nil && len(arg) <= 2 && months > 0
+}
+
+func isPositiveNumber(arg string) bool {
+	number, err := strconv.Atoi(arg)
+	return err == nil && number > 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/minya/erc/erclib"
+)
+
+// cycleStats counts work done during a single notifier cycle
+type cycleStats struct {
+	Users        int64
+	AccountCalls int64
+	BalanceCalls int64
+	Logins       int64
+}
+
+// ERCCalls is the total number of ERC client calls made during the cycle
+func (s *cycleStats) ERCCalls() int64 {
+	return atomic.LoadInt64(&s.AccountCalls) + atomic.LoadInt64(&s.BalanceCalls)
+}
+
+func (s *cycleStats) String() string {
+	return fmt.Sprintf("users checked: %v, ERC calls: %v (accounts: %v, balance: %v), ERC logins: %v",
+		atomic.LoadInt64(&s.Users),
+		s.ERCCalls(),
+		atomic.LoadInt64(&s.AccountCalls),
+		atomic.LoadInt64(&s.BalanceCalls),
+		atomic.LoadInt64(&s.Logins))
+}
+
+// countingERCClient counts calls to ERC and logins they cost into cycle stats.
+// erclib logs in on every call since it caches the session in a copy of the client,
+// so a user with N subscriptions costs N+1 logins until erclib keeps its session.
+type countingERCClient struct {
+	client ercclient
+	stats  *cycleStats
+}
+
+func (c countingERCClient) GetAccounts() ([]erclib.Account, error) {
+	atomic.AddInt64(&c.stats.AccountCalls, 1)
+	atomic.AddInt64(&c.stats.Logins, 1)
+	return c.client.GetAccounts()
+}
+
+func (c countingERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	atomic.AddInt64(&c.stats.BalanceCalls, 1)
+	atomic.AddInt64(&c.stats.Logins, 1)
+	return c.client.GetBalanceInfo(account, t)
+}
+
+func (c countingERCClient) GetReceipt(accNumber string) ([]byte, error) {
+	atomic.AddInt64(&c.stats.Logins, 1)
+	return c.client.GetReceipt(accNumber)
+}
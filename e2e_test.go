@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/minya/ercInfoBot/internal/fakebotapi"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+const webhookSecret = "webhook_secret"
+
+func TestEndToEndRegistrationToNotification(t *testing.T) {
+	env := newE2EEnv(t)
+
+	env.deliver(makeMsgUpdate("/reg login@gmail.com " + secretPassword))
+	env.expectLastMessage("Личный кабинет подключен")
+	if deleted := env.api.DeletedMessages(); len(deleted) != 1 {
+		t.Error("Message with password must be deleted, but deleted ", deleted)
+	}
+
+	env.deliver(makeCallbackUpdate("/notify account_0"))
+	env.expectLastMessage("Вы подписаны на уведомления по лицевому счету account_0")
+
+	env.deliver(makeMsgUpdate("/receipt"))
+	docs := env.api.Documents()
+	if len(docs) != 1 || docs[0].ChatID != chatID || docs[0].FileName != "account_0.pdf" || !bytes.Equal(docs[0].Content, []byte{1}) {
+		t.Fatal("Expected receipt to be uploaded, but got ", docs)
+	}
+
+	messagesBefore := len(env.api.Messages())
+	env.ntf.runCycle(context.Background())
+	if len(env.api.Messages()) != messagesBefore {
+		t.Error("Unchanged balance must not be reported")
+	}
+
+	env.erc.setTotal(250)
+	env.ntf.runCycle(context.Background())
+	env.expectLastMessage("Итого: 100 → 250 (+150.00)")
+}
+
+func TestEndToEndRejectsUpdatesWithoutSecret(t *testing.T) {
+	env := newE2EEnv(t)
+	body := strings.NewReader(`{"update_id":1,"message":{"from":{"id":100500},"chat":{"id":404040},"text":"/reg login pass"}}`)
+
+	response, err := http.Post(env.api.Webhook().URL, "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Error("Expected 401, but got ", response.StatusCode)
+	}
+	if len(env.api.Messages()) != 0 {
+		t.Error("Forged update must not be handled")
+	}
+}
+
+// e2eEnv wires real handler, notifier, bolt storage and webhook listener to fake Bot API and ERC
+type e2eEnv struct {
+	t   *testing.T
+	api *fakebotapi.Server
+	erc *mutableERC
+	ntf notifier
+}
+
+func newE2EEnv(t *testing.T) e2eEnv {
+	api := newFakeBotAPI(t)
+	bot := newTestBotAPI(api)
+	storage, err := model.NewBoltStorage(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+
+	erc := &mutableERC{client: createBalanceClient("Январь", 100)}
+	h := createHandler(storage, storage, erc.build, bot)
+	listener := httptest.NewServer(updatesServer{
+		handle:      h.handle,
+		sender:      bot,
+		path:        "/hook",
+		secretToken: webhookSecret,
+	})
+	t.Cleanup(listener.Close)
+	api.SetWebhook(fakebotapi.Webhook{URL: listener.URL + "/hook", SecretToken: webhookSecret})
+
+	ntf := createNotifier(storage, storage, erc.build, bot, 0, NotifierSettings{})
+	return e2eEnv{t: t, api: api, erc: erc, ntf: ntf}
+}
+
+// deliver posts update to the webhook the way Telegram does
+func (env e2eEnv) deliver(upd telegram.Update) {
+	status, err := env.api.Deliver(upd)
+	if err != nil || status != http.StatusOK {
+		env.t.Fatal("Update was not accepted: ", status, err)
+	}
+}
+
+func (env e2eEnv) expectLastMessage(text string) {
+	messages := env.api.Messages()
+	if len(messages) == 0 {
+		env.t.Fatalf("Expected message %q, but nothing was sent", text)
+	}
+	last := messages[len(messages)-1]
+	if last.ChatId != chatID || !strings.Contains(last.Text, text) {
+		env.t.Fatalf("Expected message %q, but got %+v", text, last)
+	}
+}
+
+// mutableERC lets test change balance between checks
+type mutableERC struct {
+	mu     sync.Mutex
+	client fakeERCClient
+}
+
+func (e *mutableERC) build(login string, password string) ercclient {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.client
+}
+
+func (e *mutableERC) setTotal(total float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.client = createBalanceClient(e.client.balance.Month, total)
+}
@@ -8,6 +8,10 @@ require (
 	github.com/minya/googleapis v0.0.0-20230425192639-9b808e3c670e
 	github.com/minya/goutils v0.0.0-20180115114943-130dc18ce623
 	github.com/minya/telegram v0.0.0-20230226002341-3f56a12f31e0
+	go.etcd.io/bbolt v1.3.7
 )
 
-require golang.org/x/text v0.9.0 // indirect
+require (
+	golang.org/x/sys v0.5.0 // indirect
+	golang.org/x/text v0.9.0 // indirect
+)
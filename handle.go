@@ -1,8 +1,8 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
@@ -17,121 +17,183 @@ type ercclient interface {
 	GetReceipt(accNumber string) ([]byte, error)
 }
 
+type messageDeleter interface {
+	DeleteMessage(ctx context.Context, chatID int, messageID int) error
+}
+
 type handler struct {
 	storage        model.UserStorage
+	history        model.HistoryStorage
 	buildERCClient func(string, string) ercclient
+	bot            messageDeleter
 }
 
-func createHandler(storage model.UserStorage, buildERCClient func(string, string) ercclient) handler {
-	return handler{storage: storage, buildERCClient: buildERCClient}
+func createHandler(
+	storage model.UserStorage,
+	history model.HistoryStorage,
+	buildERCClient func(string, string) ercclient,
+	bot messageDeleter) handler {
+	return handler{storage: storage, history: history, buildERCClient: buildERCClient, bot: bot}
 }
 
 //handle every incoming update
-func (h *handler) handle(upd telegram.Update) interface{} {
-	log.Printf("Update: %v\n", upd)
+func (h *handler) handle(ctx context.Context, upd telegram.Update) interface{} {
 	userID := upd.CallbackQuery.From.Id
 	if userID == 0 {
 		userID = upd.Message.From.Id
 	}
 
-	userInfo, userInfoErr := h.storage.GetUserInfo(userID)
+	ctx = withLogFields(ctx, "user", userID, "chat", getReplyToChatID(upd))
+	log := loggerFrom(ctx)
 
+	userInfo, userInfoErr := h.storage.GetUserInfo(ctx, userID)
+	log.debugf("Update: %v", redactUpdate(upd, userInfo.Conversation.AwaitsSecret()))
 	if nil != userInfoErr {
-		log.Printf("Login not found for user %v. Creating stub.\n", userID)
-		h.storage.SaveUser(userID, userInfo)
-	} else {
-		log.Printf("Login for user %v found: %v\n", userID, userInfo.Login)
+		// the stored user is left as is: writing over it would lose what couldn't be read
+		log.errorf("Unable to get user: %v", userInfoErr)
+		commandsTotal.inc(outcomeUnknown, outcomeError)
+		return replyWithMessage(upd, newTranslator(userLanguage(model.UserInfo{}, upd)).text("error"))
+	}
+	log.debugf("Login found: %v", userInfo.Login)
+
+	if userInfo.Language == "" && detectLanguage(getSender(upd).LanguageCode) != "" {
+		userInfo.Language = userLanguage(userInfo, upd)
+		if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+			log.errorf("Error while saving user: %v", err)
+		}
 	}
+	tr := newTranslator(userLanguage(userInfo, upd))
 
 	cmdText := upd.CallbackQuery.Data
 	if cmdText == "" {
-		log.Printf("Parse cmd from Message\n")
 		cmdText = upd.Message.Text
+		if continuesConversation(userInfo.Conversation, cmdText) {
+			commandsTotal.inc("conversation", outcomeOK)
+			return h.continueConversation(ctx, upd, userID, userInfo, tr)
+		}
 	}
 	cmd, cmdParseErr := ParseCommand(cmdText)
 	if cmdParseErr != nil {
-		log.Printf("Error parse command: %v\n", cmdParseErr)
-		return help(upd)
+		log.infof("Error parse command: %v", cmdParseErr)
+		if usageErr, ok := cmdParseErr.(usageError); ok {
+			commandsTotal.inc(cmd.Command, outcomeInvalid)
+			return replyWithMessage(upd, usageErr.text(tr))
+		}
+		commandsTotal.inc(outcomeUnknown, outcomeUnknown)
+		return help(upd, tr)
 	}
 
-	log.Printf("Process command: %v\n", cmd)
+	ctx = withLogFields(ctx, "command", cmd.Command)
+	log = loggerFrom(ctx)
+	log.infof("Process command: %v", cmd)
 
-	if cmd.Command == "/reg" {
-		return h.register(upd, cmd.Args[0], cmd.Args[1])
+	spec, _ := findCommand(cmd.Command)
+	req := commandRequest{ctx: ctx, upd: upd, userID: userID, userInfo: userInfo, args: cmd.Args, tr: tr}
+	if spec.access == accessAnyone {
+		commandsTotal.inc(cmd.Command, outcomeOK)
+		return spec.run(h, req)
 	}
 
-	if cmd.Command == "/help" {
-		return help(upd)
+	log.debugf("User: %v", userInfo)
+	if userInfo.Login == "" {
+		commandsTotal.inc(cmd.Command, outcomeUnregistered)
+		return replyWithMessage(upd, tr.text("login.required"))
 	}
 
-	log.Printf("USERINFO %v\n", userInfo)
-	if userInfo.Login == "" {
-		return replyWithMessage(
-			upd, "Подключите личный кабинет: /reg <login> <password>")
+	if spec.access == accessSubscription && len(cmd.Args) > 0 && cmd.Args[0] != "" {
+		req.ctx = withLogFields(ctx, "account", cmd.Args[0])
+		req.account = erclib.Account{Number: cmd.Args[0]}
+		commandsTotal.inc(cmd.Command, outcomeOK)
+		return spec.run(h, req)
 	}
 
 	var accountNum string
-	ercClient := h.buildERCClient(userInfo.Login, userInfo.Password)
-	accounts, _ := ercClient.GetAccounts()
+	req.ercClient = h.buildERCClient(userInfo.Login, userInfo.Password)
+	accounts, _ := req.ercClient.GetAccounts()
 	if len(cmd.Args) == 0 || cmd.Args[0] == "" {
-		log.Printf("No account in query")
+		log.debugf("No account in query")
+		if len(accounts) == 0 {
+			commandsTotal.inc(cmd.Command, outcomeError)
+			return replyWithMessage(upd, tr.text("accounts.unavailable"))
+		}
 		if len(accounts) > 1 {
-			return replyChooseAccount(upd.Message.Chat.Id, cmd.Command, accounts)
+			commandsTotal.inc(cmd.Command, outcomeOK)
+			return replyChooseAccount(getReplyToChatID(upd), cmd.Command, argsAfterAccount(cmd.Args), accounts, userInfo.Subscriptions, tr)
 		}
 		accountNum = accounts[0].Number
 	} else {
 		accountNum = cmd.Args[0]
 	}
 
-	log.Printf("Account number is %v\n", accountNum)
+	req.ctx = withLogFields(ctx, "account", accountNum)
 
 	account, errNoAccount := findAccount(accounts, accountNum)
 	if errNoAccount != nil {
-		return replyWithMessage(
-			upd,
-			fmt.Sprintf("Лицевой счет %v не найден среди подключенных в личном кабинете", accountNum))
-	}
-
-	switch cmd.Command {
-	case "/notify":
-		return h.setUpNotification(upd, ercClient, account)
-	case "/get":
-		return get(upd, ercClient, account)
-	case "/receipt":
-		return receipt(upd, ercClient, account)
-	default:
-		log.Printf("Unknown command: %v\n", cmd.Command)
-		return help(upd)
+		commandsTotal.inc(cmd.Command, outcomeNoAccount)
+		return replyWithMessage(upd, tr.text("account.notFound", accountNum))
 	}
+	req.account = account
+	commandsTotal.inc(cmd.Command, outcomeOK)
+	return spec.run(h, req)
 }
 
-func replyChooseAccount(chatID int, sourceCmd string, accounts []erclib.Account) telegram.ReplyMessage {
+func replyChooseAccount(
+	chatID int,
+	sourceCmd string,
+	sourceArgs []string,
+	accounts []erclib.Account,
+	subscriptions map[string]model.SubscriptionInfo,
+	tr translator) telegram.ReplyMessage {
 	return telegram.ReplyMessage{
 		ChatId:      chatID,
-		Text:        fmt.Sprintf("По какому лицевому счету вы хотите %v?", makeOpName(sourceCmd)),
-		ReplyMarkup: chooseAccountButtons(sourceCmd, accounts),
+		Text:        tr.text("account.choose", makeOpName(sourceCmd, tr)),
+		ReplyMarkup: chooseAccountButtons(sourceCmd, sourceArgs, accounts, subscriptions),
 	}
 }
 
-func makeOpName(cmd string) string {
+// argsAfterAccount keeps arguments following the missing account, e.g. months of "/history 3"
+func argsAfterAccount(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	return args[1:]
+}
+
+func makeOpName(cmd string, tr translator) string {
 	switch cmd {
 	case "/get":
-		return "получить баланс"
+		return tr.text("op.get")
 	case "/receipt":
-		return "получить квитанцию"
+		return tr.text("op.receipt")
+	case "/history":
+		return tr.text("op.history")
 	case "/notify":
-		return "настроить уведомления"
+		return tr.text("op.notify")
+	case "/unsubscribe":
+		return tr.text("op.unsubscribe")
+	case "/alert":
+		return tr.text("op.alert")
 	}
-	return "произвести операцию"
+	return tr.text("op.other")
 }
 
-func chooseAccountButtons(sourceCmd string, accounts []erclib.Account) telegram.InlineKeyboardMarkup {
+// chooseAccountButtons makes a button per account repeating sourceCmd with sourceArgs.
+// For notification commands buttons show subscription state and toggle it.
+func chooseAccountButtons(
+	sourceCmd string,
+	sourceArgs []string,
+	accounts []erclib.Account,
+	subscriptions map[string]model.SubscriptionInfo) telegram.InlineKeyboardMarkup {
 	keyboard := [][]telegram.InlineKeyboardButton{}
 	for _, account := range accounts {
+		text, cmd := account.Address, sourceCmd
+		if sourceCmd == "/notify" || sourceCmd == "/unsubscribe" {
+			text, cmd = toggleSubscriptionButton(account, subscriptions)
+		}
 		keyboard = append(keyboard, []telegram.InlineKeyboardButton{
 			telegram.InlineKeyboardButton{
-				Text:         account.Address,
-				CallbackData: fmt.Sprintf("%v %v", sourceCmd, account.Number),
+				Text:         text,
+				CallbackData: strings.Join(append([]string{cmd, account.Number}, sourceArgs...), " "),
 			},
 		})
 	}
@@ -141,40 +203,58 @@ func chooseAccountButtons(sourceCmd string, accounts []erclib.Account) telegram.
 	}
 }
 
-func (h *handler) register(upd telegram.Update, login string, password string) interface{} {
-	ercClient := erclib.NewErcClientWithCredentials(login, password)
+func toggleSubscriptionButton(
+	account erclib.Account, subscriptions map[string]model.SubscriptionInfo) (string, string) {
+	if isSubscribed(subscriptions, account.Number) {
+		return "🔔 " + account.Address, "/unsubscribe"
+	}
+	return "🔕 " + account.Address, "/notify"
+}
+
+func isSubscribed(subscriptions map[string]model.SubscriptionInfo, accountNum string) bool {
+	sub, ok := subscriptions[accountNum]
+	return ok && sub.ChatID != 0
+}
+
+func (h *handler) register(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, login string, password string,
+	tr translator) interface{} {
+	ercClient := h.buildERCClient(login, password)
 	accounts, errAccounts := ercClient.GetAccounts()
 	if errAccounts != nil {
 		return telegram.ReplyMessage{
 			ChatId: upd.Message.Chat.Id,
-			Text:   "Wrong login/password. Please, register: /reg <login> <password>",
+			Text:   tr.text("reg.wrongCredentials"),
 		}
 	}
 
-	var userInfo model.UserInfo
 	userInfo.Login = login
 	userInfo.Password = password
+	userInfo.Conversation = nil
+	userInfo.Health = nil
 
-	saveErr := h.storage.SaveUser(upd.Message.From.Id, userInfo)
+	saveErr := h.storage.SaveUser(ctx, userID, userInfo)
 
 	if saveErr != nil {
-		log.Printf(fmt.Sprintf("Error while saving user: %v\n", saveErr))
+		loggerFrom(ctx).errorf("Error while saving user: %v", saveErr)
 		return telegram.ReplyMessage{
 			ChatId: upd.Message.Chat.Id,
-			Text:   "Error while registering user",
+			Text:   tr.text("reg.failed"),
 		}
 	}
 
 	return telegram.ReplyMessage{
-		ChatId: upd.Message.Chat.Id,
-		Text: fmt.Sprintf("You have been registered. "+
-			"Your accounts are : %v", listAccounts(&accounts)),
+		ChatId:      upd.Message.Chat.Id,
+		Text:        tr.plural("reg.done", len(accounts), len(accounts), listAccounts(&accounts)),
 		ReplyMarkup: replyButtons(),
 	}
 }
 
-func get(upd telegram.Update, ercClient ercclient, account erclib.Account) interface{} {
-	balanceInfo, _ := ercClient.GetBalanceInfo(account.Number, time.Now())
+func (h *handler) get(ctx context.Context, upd telegram.Update, userID int, ercClient ercclient, account erclib.Account) interface{} {
+	balanceInfo, err := ercClient.GetBalanceInfo(account.Number, time.Now())
+	if err == nil {
+		recordBalance(ctx, h.history, userID, account.Number, balanceInfo, time.Now())
+	}
 	return telegram.ReplyMessage{
 		ChatId:      getReplyToChatID(upd),
 		Text:        formatBalance(account, balanceInfo),
@@ -182,16 +262,17 @@ func get(upd telegram.Update, ercClient ercclient, account erclib.Account) inter
 	}
 }
 
-func receipt(upd telegram.Update, ercClient ercclient, account erclib.Account) interface{} {
+func receipt(
+	ctx context.Context, upd telegram.Update, ercClient ercclient, account erclib.Account, tr translator) interface{} {
 	receipt, err := ercClient.GetReceipt(account.Number)
 	if err != nil {
-		log.Printf("%v\n", err)
-		return replyWithMessage(upd, "Не удалось загрузить квитанцию")
+		loggerFrom(ctx).errorf("Unable to get receipt: %v", err)
+		return replyWithMessage(upd, tr.text("receipt.failed"))
 	}
 
 	return telegram.ReplyDocument{
 		ChatId:  getReplyToChatID(upd),
-		Caption: fmt.Sprintf("Квитанция (%v)", account.Address),
+		Caption: tr.text("receipt.caption", account.Address),
 		InputFile: telegram.InputFile{
 			Content:  receipt,
 			FileName: fmt.Sprintf("%v.pdf", account.Number),
@@ -201,22 +282,25 @@ func receipt(upd telegram.Update, ercClient ercclient, account erclib.Account) i
 }
 
 func (h *handler) setUpNotification(
+	ctx context.Context,
 	upd telegram.Update,
 	ercClient ercclient,
-	account erclib.Account) telegram.ReplyMessage {
+	account erclib.Account,
+	tr translator) telegram.ReplyMessage {
 
 	balanceInfo, err := ercClient.GetBalanceInfo(account.Number, time.Now())
-	var lastSeenState string
+	var lastSeen *model.BalanceSnapshot
 	if err == nil {
-		lastSeenState = fmt.Sprintf("%v", balanceInfo)
+		snapshot := snapshotBalance(balanceInfo)
+		lastSeen = &snapshot
 	}
 	userID := getUserID(upd)
-	user, err := h.storage.GetUserInfo(userID)
+	user, err := h.storage.GetUserInfo(ctx, userID)
 	chatID := getReplyToChatID(upd)
 	if err != nil {
 		return telegram.ReplyMessage{
 			ChatId:      chatID,
-			Text:        "Ошибка",
+			Text:        tr.text("error"),
 			ReplyMarkup: replyButtons(),
 		}
 	}
@@ -224,23 +308,48 @@ func (h *handler) setUpNotification(
 	if user.Subscriptions == nil {
 		user.Subscriptions = make(map[string]model.SubscriptionInfo)
 	}
-	user.Subscriptions[account.Number] = model.SubscriptionInfo{
-		ChatID:        chatID,
-		LastSeenState: lastSeenState,
-	}
+	sub := user.Subscriptions[account.Number]
+	sub.ChatID, sub.LastSeen = chatID, lastSeen
+	user.Subscriptions[account.Number] = sub
 
-	h.storage.SaveUser(userID, user)
+	h.storage.SaveUser(ctx, userID, user)
 
 	return telegram.ReplyMessage{
-		ChatId: getReplyToChatID(upd),
-		Text: fmt.Sprintf(
-			"Вы подписаны на уведомления по лицевому счету %v (%v)",
-			account.Number,
-			account.Address),
+		ChatId:      getReplyToChatID(upd),
+		Text:        tr.text("notify.subscribed", account.Number, account.Address),
 		ReplyMarkup: replyButtons(),
 	}
 }
 
+func (h *handler) unsubscribe(
+	ctx context.Context, upd telegram.Update, account erclib.Account, tr translator) telegram.ReplyMessage {
+	userID := getUserID(upd)
+	user, err := h.storage.GetUserInfo(ctx, userID)
+	if err != nil {
+		return replyWithMessage(upd, tr.text("error"))
+	}
+
+	if !isSubscribed(user.Subscriptions, account.Number) {
+		return replyWithMessage(upd, tr.text("notify.notSubscribed", accountTitle(account)))
+	}
+
+	delete(user.Subscriptions, account.Number)
+	if err = h.storage.SaveUser(ctx, userID, user); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+
+	return replyWithMessage(upd, tr.text("notify.unsubscribed", accountTitle(account)))
+}
+
+// accountTitle is account's number followed by its address if it's known
+func accountTitle(account erclib.Account) string {
+	if account.Address == "" {
+		return account.Number
+	}
+	return fmt.Sprintf("%v (%v)", account.Number, account.Address)
+}
+
 func getReplyToChatID(upd telegram.Update) int {
 	chatToReply := upd.Message.Chat.Id
 	if chatToReply == 0 {
@@ -285,16 +394,10 @@ func listAccounts(accounts *[]erclib.Account) string {
 	return builder.String()
 }
 
-func help(upd telegram.Update) telegram.ReplyMessage {
-	helpMsg :=
-		"/reg <login> <password> – Подключить личный кабинет\n" +
-			"/receipt – Скачать квитанцию в pdf\n" +
-			"/get – получить информацию о задолженности\n" +
-			"/notify – подключить уведомления о задолженности"
-
+func help(upd telegram.Update, tr translator) telegram.ReplyMessage {
 	return telegram.ReplyMessage{
 		ChatId: upd.Message.Chat.Id,
-		Text:   helpMsg,
+		Text:   helpText(tr),
 	}
 }
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,8 +17,8 @@ func TestHandleReceiptReturnsDocumentIfThereIsTheOnlyAccount(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(1)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(upd)
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), upd)
 	ensureDocumentWithButtons(t, reply)
 }
 
@@ -25,8 +27,8 @@ func TestHandleGetReturnsResultIfThereIsTheOnlyAccount(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(1)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(upd)
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), upd)
 	ensureMessageWithButtons(t, reply)
 }
 
@@ -36,8 +38,8 @@ func TestHandleReturnsChoiceIfMultipleAccountsAndNoAccountSpecified(t *testing.T
 		var makeClient = func(l string, p string) ercclient {
 			return createFakeERCClient(2)
 		}
-		h := createHandler(createFakeStorage(), makeClient)
-		reply := h.handle(upd).(telegram.ReplyMessage)
+		h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+		reply := h.handle(context.Background(), upd).(telegram.ReplyMessage)
 		_ = reply.ReplyMarkup.(telegram.InlineKeyboardMarkup)
 	}
 
@@ -51,8 +53,8 @@ func TestHandleGetReturnsResultIfAccountIsPassedInMessage(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(2)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(upd)
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), upd)
 	ensureMessageWithButtons(t, reply)
 }
 
@@ -61,8 +63,8 @@ func TestHandleGetReturnsResultIfAccountIsPassedInCallback(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(2)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(upd)
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), upd)
 	ensureMessageWithButtons(t, reply)
 }
 
@@ -70,8 +72,8 @@ func TestHandleReceiptReturnsDocumentIfAccountIsPassedInMessage(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(2)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(makeMsgUpdate("/receipt account_0"))
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), makeMsgUpdate("/receipt account_0"))
 	ensureDocumentWithButtons(t, reply)
 }
 
@@ -79,8 +81,8 @@ func TestHandleReceiptReturnsDocumentIfAccountIsPassedInCallback(t *testing.T) {
 	var makeClient = func(l string, p string) ercclient {
 		return createFakeERCClient(2)
 	}
-	h := createHandler(createFakeStorage(), makeClient)
-	reply := h.handle(makeCallbackUpdate("/receipt account_0"))
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), makeCallbackUpdate("/receipt account_0"))
 	ensureDocumentWithButtons(t, reply)
 }
 
@@ -102,8 +104,8 @@ func TestNotifyWritesDataToStorage(t *testing.T) {
 			}
 			userWritten = true
 		}
-		h := createHandler(createFakeStorageCapturingWrites(onUserSave), makeClient)
-		reply := h.handle(upd)
+		h := createHandler(createFakeStorageCapturingWrites(onUserSave), newFakeHistory(), makeClient, &fakeBot{})
+		reply := h.handle(context.Background(), upd)
 		_ = reply.(telegram.ReplyMessage)
 		if !userWritten {
 			t.Error("User was never written")
@@ -115,6 +117,102 @@ func TestNotifyWritesDataToStorage(t *testing.T) {
 	doTest(t, makeMsgUpdate("/notify"), 1)
 }
 
+func TestUnsubscribeRemovesSubscription(t *testing.T) {
+	var doTest = func(t *testing.T, upd telegram.Update, numAccounts uint) {
+		var makeClient = func(l string, p string) ercclient {
+			return createFakeERCClient(numAccounts)
+		}
+		storage := createFakeStorageWithSubscriptions("account_0", "account_1")
+		h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+		reply := h.handle(context.Background(), upd)
+		ensureMessageWithButtons(t, reply)
+		if _, ok := storage.userInfo.Subscriptions["account_0"]; ok {
+			t.Error("Subscription was not removed")
+		}
+		if _, ok := storage.userInfo.Subscriptions["account_1"]; !ok {
+			t.Error("Other subscription must stay")
+		}
+	}
+
+	doTest(t, makeCallbackUpdate("/unsubscribe account_0"), 2)
+	doTest(t, makeMsgUpdate("/unsubscribe account_0"), 2)
+	doTest(t, makeMsgUpdate("/unsubscribe"), 1)
+}
+
+func TestUnsubscribeWithAccountWorksWhenERCIsUnavailable(t *testing.T) {
+	var makeClient = func(l string, p string) ercclient {
+		client := createFakeERCClient(0)
+		client.accountsErr = fmt.Errorf("Authentication error")
+		return client
+	}
+	storage := createFakeStorageWithSubscriptions("account_0")
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/unsubscribe account_0"))
+
+	if msg := reply.(telegram.ReplyMessage); !strings.Contains(msg.Text, "account_0 отключены") {
+		t.Error("Unexpected reply: ", msg.Text)
+	}
+	if isSubscribed(storage.userInfo.Subscriptions, "account_0") {
+		t.Error("Subscription was not removed")
+	}
+}
+
+func TestUnsubscribeWhenNotSubscribedDoesNotWrite(t *testing.T) {
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	var onUserSave = func(savingUserID int, user model.UserInfo) {
+		t.Error("User must not be written")
+	}
+	h := createHandler(createFakeStorageCapturingWrites(onUserSave), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), makeMsgUpdate("/unsubscribe account_0"))
+	ensureMessageWithButtons(t, reply)
+}
+
+func TestNotificationChoiceShowsSubscriptionState(t *testing.T) {
+	for _, cmd := range []string{"/notify", "/unsubscribe"} {
+		var makeClient = func(l string, p string) ercclient {
+			return createFakeERCClient(2)
+		}
+		h := createHandler(createFakeStorageWithSubscriptions("account_0"), newFakeHistory(), makeClient, &fakeBot{})
+		reply := h.handle(context.Background(), makeMsgUpdate(cmd)).(telegram.ReplyMessage)
+		keyboard := reply.ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+
+		subscribed, unsubscribed := keyboard[0][0], keyboard[1][0]
+		if subscribed.Text != "🔔 Address 0" || subscribed.CallbackData != "/unsubscribe account_0" {
+			t.Error("Unexpected button for subscribed account: ", subscribed)
+		}
+		if unsubscribed.Text != "🔕 Address 1" || unsubscribed.CallbackData != "/notify account_1" {
+			t.Error("Unexpected button for unsubscribed account: ", unsubscribed)
+		}
+	}
+}
+
+func TestHandleDoesNotWriteOverUnreadableUser(t *testing.T) {
+	storage := &unreadableStorage{fakeStorage: createFakeStorageCapturingWrites(func(int, model.UserInfo) {
+		t.Error("User must not be written after read error")
+	})}
+	h := createHandler(storage, newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/help"))
+
+	if msg := reply.(telegram.ReplyMessage); msg.Text != newTranslator(langRU).text("error") {
+		t.Error("Expected error reply, but got ", msg.Text)
+	}
+}
+
+// unreadableStorage fails to read users, e.g. when their credentials can't be decrypted
+type unreadableStorage struct {
+	*fakeStorage
+}
+
+func (s *unreadableStorage) GetUserInfo(ctx context.Context, userID int) (model.UserInfo, error) {
+	return model.UserInfo{}, fmt.Errorf("Unknown encryption key")
+}
+
 func ensureDocumentWithButtons(t *testing.T, reply interface{}) {
 	doc := reply.(telegram.ReplyDocument)
 	_ = doc.ReplyMarkup.(telegram.ReplyKeyboardMarkup)
@@ -173,36 +271,51 @@ var chatID = 404040
 type fakeStorage struct {
 	userInfo model.UserInfo
 	onWrite  func(int, model.UserInfo)
+	deleted  []int
 }
 
-func (s fakeStorage) GetUserInfo(userID int) (model.UserInfo, error) {
+func (s *fakeStorage) GetUserInfo(ctx context.Context, userID int) (model.UserInfo, error) {
 	return s.userInfo, nil
 }
 
-func (s fakeStorage) SaveUser(userID int, userInfo model.UserInfo) error {
+func (s *fakeStorage) SaveUser(ctx context.Context, userID int, userInfo model.UserInfo) error {
 	if s.onWrite != nil {
 		s.onWrite(userID, userInfo)
 	}
+	s.userInfo = userInfo
+	return nil
+}
+func (s *fakeStorage) DeleteUser(ctx context.Context, userID int) error {
+	s.deleted = append(s.deleted, userID)
+	s.userInfo = model.UserInfo{}
 	return nil
 }
-func (s fakeStorage) GetUsers() (map[int]model.UserInfo, error) {
+
+func (s *fakeStorage) GetUsers(ctx context.Context) (map[int]model.UserInfo, error) {
 	return map[int]model.UserInfo{123: s.userInfo}, nil
 }
 
 type fakeERCClient struct {
-	accounts []erclib.Account
+	accounts    []erclib.Account
+	accountsErr error
+	balance     *erclib.BalanceInfo
+	balanceErr  error
 }
 
 func (f fakeERCClient) GetAccounts() ([]erclib.Account, error) {
-	return f.accounts, nil
+	return f.accounts, f.accountsErr
 }
 
 func (f fakeERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	if f.balance != nil || f.balanceErr != nil {
+		if f.balance == nil {
+			return erclib.BalanceInfo{}, f.balanceErr
+		}
+		return *f.balance, f.balanceErr
+	}
 	balance := erclib.BalanceInfo{
-		Month:    "Январь",
-		Credit:   erclib.Details{},
-		Debit:    erclib.Details{},
-		AtTheEnd: erclib.Details{},
+		Month: "Январь",
+		Rows:  []erclib.BalanceRow{},
 	}
 	return balance, nil
 }
@@ -223,10 +336,45 @@ func createFakeERCClient(numAccounts uint) fakeERCClient {
 	return fakeERCClient{accounts: result}
 }
 
-func createFakeStorage() fakeStorage {
-	return fakeStorage{userInfo: model.UserInfo{Login: "login@gmail.com"}}
+func createFakeStorage() *fakeStorage {
+	return &fakeStorage{userInfo: model.UserInfo{Login: "login@gmail.com"}}
 }
 
-func createFakeStorageCapturingWrites(onWrite func(int, model.UserInfo)) fakeStorage {
-	return fakeStorage{userInfo: model.UserInfo{Login: "login@gmail.com"}, onWrite: onWrite}
+func createFakeStorageCapturingWrites(onWrite func(int, model.UserInfo)) *fakeStorage {
+	return &fakeStorage{userInfo: model.UserInfo{Login: "login@gmail.com"}, onWrite: onWrite}
+}
+
+func createFakeStorageWithSubscriptions(accounts ...string) *fakeStorage {
+	storage := createFakeStorage()
+	storage.userInfo.Subscriptions = make(map[string]model.SubscriptionInfo)
+	for _, account := range accounts {
+		storage.userInfo.Subscriptions[account] = model.SubscriptionInfo{ChatID: chatID}
+	}
+	return storage
+}
+
+type fakeHistory struct {
+	entries map[string][]model.BalanceHistoryEntry
+}
+
+func newFakeHistory() *fakeHistory {
+	return &fakeHistory{entries: make(map[string][]model.BalanceHistoryEntry)}
+}
+
+func (f *fakeHistory) AppendBalance(ctx context.Context, userID int, account string, entry model.BalanceHistoryEntry) (bool, error) {
+	f.entries[account] = append(f.entries[account], entry)
+	return true, nil
+}
+
+func (f *fakeHistory) GetBalanceHistory(ctx context.Context, userID int, account string) ([]model.BalanceHistoryEntry, error) {
+	return f.entries[account], nil
+}
+
+type fakeBot struct {
+	deleted []int
+}
+
+func (b *fakeBot) DeleteMessage(ctx context.Context, chatID int, messageID int) error {
+	b.deleted = append(b.deleted, messageID)
+	return nil
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Readiness checks
+const (
+	checkStorage  = "storage"
+	checkListener = "listener"
+)
+
+var (
+	errNotChecked   = errors.New("not checked yet")
+	errShuttingDown = errors.New("shutting down")
+)
+
+// readiness collects the state of components the bot can't work without
+type readiness struct {
+	mu     sync.Mutex
+	checks map[string]error
+}
+
+// newReadiness makes readiness failing every check until it is set
+func newReadiness(checks ...string) *readiness {
+	r := &readiness{checks: make(map[string]error)}
+	for _, check := range checks {
+		r.checks[check] = errNotChecked
+	}
+	return r
+}
+
+// set records the latest state of a check, nil means healthy
+func (r *readiness) set(check string, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[check] = err
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.checks))
+	status := http.StatusOK
+	for name, err := range r.checks {
+		names = append(names, name)
+		if err != nil {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, name := range names {
+		state := "ok"
+		if err := r.checks[name]; err != nil {
+			state = err.Error()
+		}
+		fmt.Fprintf(w, "%v: %v\n", name, state)
+	}
+}
+
+// newMonitoringHandler serves metrics and health probes.
+// /healthz tells the process is alive, /readyz that it can serve users.
+func newMonitoringHandler(metrics http.Handler, ready *readiness) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/readyz", ready)
+	return mux
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyzFailsUntilEverythingIsReady(t *testing.T) {
+	ready := newReadiness(checkStorage, checkListener)
+	handler := newMonitoringHandler(&metricsRegistry{}, ready)
+
+	status, body := probe(handler, "/readyz")
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, "storage: not checked yet") {
+		t.Error("Expected not ready, but got ", status, body)
+	}
+
+	ready.set(checkStorage, nil)
+	ready.set(checkListener, nil)
+	if status, body = probe(handler, "/readyz"); status != http.StatusOK {
+		t.Error("Expected ready, but got ", status, body)
+	}
+
+	ready.set(checkListener, errors.New("connection refused"))
+	status, body = probe(handler, "/readyz")
+	if status != http.StatusServiceUnavailable || body != "listener: connection refused\nstorage: ok\n" {
+		t.Error("Expected listener failure, but got ", status, body)
+	}
+}
+
+func TestHealthzAndMetrics(t *testing.T) {
+	registry := &metricsRegistry{}
+	registry.counter("test_total", "Test counter.").inc()
+	handler := newMonitoringHandler(registry, newReadiness(checkStorage))
+
+	if status, _ := probe(handler, "/healthz"); status != http.StatusOK {
+		t.Error("Expected alive while not ready, but got ", status)
+	}
+	if status, body := probe(handler, "/metrics"); status != http.StatusOK || !strings.Contains(body, "test_total 1\n") {
+		t.Error("Unexpected metrics: ", status, body)
+	}
+}
+
+func probe(handler http.Handler, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
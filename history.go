@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+const (
+	defaultHistoryMonths = 6
+	maxHistoryMonths     = 24
+	historyRequisiteLen  = 20
+	historyMonthLen      = 10
+)
+
+// recordBalance appends observed balance to account's history
+func recordBalance(
+	ctx context.Context,
+	history model.HistoryStorage, userID int, accountNum string, balance erclib.BalanceInfo, observedAt time.Time) {
+	entry := model.BalanceHistoryEntry{ObservedAt: observedAt, Balance: snapshotBalance(balance)}
+	if _, err := history.AppendBalance(ctx, userID, accountNum, entry); err != nil {
+		loggerFrom(ctx).errorf("Unable to save balance history: %v", err)
+	}
+}
+
+func (h *handler) showHistory(ctx context.Context, upd telegram.Update, userID int, account erclib.Account, args []string, tr translator) interface{} {
+	months := defaultHistoryMonths
+	if len(args) > 1 {
+		// validated by the command spec
+		if parsed, err := strconv.Atoi(args[1]); err == nil {
+			months = parsed
+		}
+	}
+	if months > maxHistoryMonths {
+		months = maxHistoryMonths
+	}
+
+	entries, err := h.history.GetBalanceHistory(ctx, userID, account.Number)
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to read balance history: %v", err)
+		return replyWithMessage(upd, tr.text("history.failed"))
+	}
+	if len(entries) == 0 {
+		return replyWithMessage(upd, tr.text("history.empty", account.Number))
+	}
+
+	return telegram.ReplyMessage{
+		ChatId:      getReplyToChatID(upd),
+		Text:        formatHistory(account, monthlyBalances(entries, months)),
+		ParseMode:   "HTML",
+		ReplyMarkup: replyButtons(),
+	}
+}
+
+// monthlyBalances takes the latest observed balance of each month, at most months of them, oldest first
+func monthlyBalances(entries []model.BalanceHistoryEntry, months int) []model.BalanceSnapshot {
+	var result []model.BalanceSnapshot
+	for _, entry := range entries {
+		if len(result) > 0 && result[len(result)-1].Month == entry.Balance.Month {
+			result[len(result)-1] = entry.Balance
+			continue
+		}
+		result = append(result, entry.Balance)
+	}
+	if len(result) > months {
+		result = result[len(result)-months:]
+	}
+	return result
+}
+
+// formatHistory renders a table: a row per requisite, a column per month
+func formatHistory(account erclib.Account, balances []model.BalanceSnapshot) string {
+	var requisites []string
+	amounts := make(map[string][]string)
+	for i, balance := range balances {
+		for _, row := range balance.Rows {
+			if _, ok := amounts[row.Requisite]; !ok {
+				requisites = append(requisites, row.Requisite)
+				amounts[row.Requisite] = make([]string, len(balances))
+			}
+			amounts[row.Requisite][i] = strconv.FormatFloat(row.Amount, 'f', 2, 64)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(html.EscapeString(account.Address))
+	sb.WriteString("\n<pre>")
+	sb.WriteString(padRight("", historyRequisiteLen))
+	for _, balance := range balances {
+		sb.WriteString(" " + padLeft(balance.Month, historyMonthLen))
+	}
+	sb.WriteString("\n")
+	for _, requisite := range requisites {
+		sb.WriteString(padRight(requisite, historyRequisiteLen))
+		for _, amount := range amounts[requisite] {
+			if amount == "" {
+				amount = "—"
+			}
+			sb.WriteString(" " + padLeft(amount, historyMonthLen))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("</pre>")
+	return sb.String()
+}
+
+func padRight(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	return html.EscapeString(string(runes)) + strings.Repeat(" ", width-len(runes))
+}
+
+func padLeft(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		runes = runes[:width]
+	}
+	return strings.Repeat(" ", width-len(runes)) + html.EscapeString(string(runes))
+}
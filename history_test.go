@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestGetRecordsBalanceHistory(t *testing.T) {
+	history := newFakeHistory()
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), history, makeClient, &fakeBot{})
+
+	h.handle(context.Background(), makeMsgUpdate("/get"))
+
+	entries := history.entries["account_0"]
+	if len(entries) != 1 || entries[0].Balance.Month != "Январь" {
+		t.Error("Expected balance to be recorded, but got ", entries)
+	}
+}
+
+func TestHistoryRendersMonthlyTable(t *testing.T) {
+	history := newFakeHistory()
+	history.entries["account_0"] = []model.BalanceHistoryEntry{
+		makeHistoryEntry(makeSnapshot("Январь", "Итого", 100.0)),
+		makeHistoryEntry(makeSnapshot("Январь", "Итого", 150.0)),
+		makeHistoryEntry(makeSnapshot("Февраль", "Итого", 200.0, "Пени", 1.5)),
+	}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), history, makeClient, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/history")).(telegram.ReplyMessage)
+
+	if reply.ParseMode != "HTML" {
+		t.Error("Expected HTML parse mode")
+	}
+	lines := strings.Split(reply.Text, "\n")
+	if !strings.Contains(lines[1], "Январь") || !strings.Contains(lines[1], "Февраль") {
+		t.Error("Expected months header, but got ", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "Итого") || !strings.HasSuffix(lines[2], "150.00     200.00") {
+		t.Error("Expected latest amounts per month, but got ", lines[2])
+	}
+	if !strings.HasPrefix(lines[3], "Пени") || !strings.Contains(lines[3], "—") {
+		t.Error("Expected missing amount mark, but got ", lines[3])
+	}
+}
+
+func TestHistoryIsLimitedByMonths(t *testing.T) {
+	entries := []model.BalanceHistoryEntry{
+		makeHistoryEntry(makeSnapshot("Январь", "Итого", 100.0)),
+		makeHistoryEntry(makeSnapshot("Февраль", "Итого", 200.0)),
+		makeHistoryEntry(makeSnapshot("Март", "Итого", 300.0)),
+	}
+	balances := monthlyBalances(entries, 2)
+	if len(balances) != 2 || balances[0].Month != "Февраль" || balances[1].Month != "Март" {
+		t.Error("Expected 2 latest months, but got ", balances)
+	}
+}
+
+func TestHistoryAsksForAccountIfMultiple(t *testing.T) {
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(2)
+	}
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), makeMsgUpdate("/history 3")).(telegram.ReplyMessage)
+	buttons := reply.ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+	if len(buttons) != 2 || buttons[1][0].CallbackData != "/history account_1 3" {
+		t.Error("Expected buttons keeping months, but got ", buttons)
+	}
+}
+
+func TestHistoryWhenEmpty(t *testing.T) {
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	reply := h.handle(context.Background(), makeMsgUpdate("/history"))
+	ensureMessageWithButtons(t, reply)
+}
+
+func TestParseHistory(t *testing.T) {
+	cases := map[string][]string{
+		"/history":              {},
+		"/history 3":            {"", "3"},
+		"/history 1234567":      {"1234567"},
+		"/history 1234567 12":   {"1234567", "12"},
+		"/history 1234567 1 2 ": {"1234567", "1"},
+	}
+	for text, expected := range cases {
+		command, err := ParseCommand(text)
+		if err != nil {
+			t.Error("Error while parse command: ", text)
+		}
+		if strings.Join(command.Args, ",") != strings.Join(expected, ",") {
+			t.Errorf("%v: expected %v, but got %v", text, expected, command.Args)
+		}
+	}
+}
+
+func makeHistoryEntry(snapshot model.BalanceSnapshot) model.BalanceHistoryEntry {
+	return model.BalanceHistoryEntry{ObservedAt: time.Now(), Balance: snapshot}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+const (
+	langRU = "ru"
+	langEN = "en"
+
+	// defaultLanguage is used when nothing is known about user's language
+	defaultLanguage = langRU
+	// fallbackLanguage is used for Telegram languages without a catalog
+	fallbackLanguage = langEN
+
+	// pluralSeparator separates plural forms of a message in catalogs
+	pluralSeparator = "|"
+)
+
+// languages lists supported languages in the order /lang shows them
+var languages = []string{langRU, langEN}
+
+// catalog maps message keys to fmt templates.
+// Plural messages hold forms separated by pluralSeparator in the order of pluralRules.
+type catalog map[string]string
+
+var catalogs = map[string]catalog{
+	langRU: ruCatalog,
+	langEN: enCatalog,
+}
+
+// pluralRules choose a plural form index for a number
+var pluralRules = map[string]func(n int) int{
+	// one: 1, 21; few: 2-4, 22; many: 0, 5-20, 25
+	langRU: func(n int) int {
+		if n < 0 {
+			n = -n
+		}
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return 1
+		default:
+			return 2
+		}
+	},
+	// one: 1; other: everything else
+	langEN: func(n int) int {
+		if n == 1 || n == -1 {
+			return 0
+		}
+		return 1
+	},
+}
+
+// translator renders messages in user's language
+type translator struct {
+	lang string
+}
+
+func newTranslator(lang string) translator {
+	if !isSupportedLanguage(lang) {
+		lang = defaultLanguage
+	}
+	return translator{lang: lang}
+}
+
+func isSupportedLanguage(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// text renders message, falling back to the default language and then to the key itself
+func (t translator) text(key string, args ...interface{}) string {
+	return fmt.Sprintf(t.template(key), args...)
+}
+
+// plural renders the form of message matching n, n itself is not passed to the template
+func (t translator) plural(key string, n int, args ...interface{}) string {
+	forms := strings.Split(t.template(key), pluralSeparator)
+	form := pluralRules[t.lang](n)
+	if form >= len(forms) {
+		form = len(forms) - 1
+	}
+	return fmt.Sprintf(forms[form], args...)
+}
+
+func (t translator) template(key string) string {
+	if template, ok := catalogs[t.lang][key]; ok {
+		return template
+	}
+	rootLogger.warnf("No message %v in %v catalog", key, t.lang)
+	if template, ok := catalogs[defaultLanguage][key]; ok {
+		return template
+	}
+	return key
+}
+
+// detectLanguage maps Telegram's IETF language tag to a supported language, "" if client sent none
+func detectLanguage(languageCode string) string {
+	if languageCode == "" {
+		return ""
+	}
+	primary := languageCode
+	if i := strings.IndexAny(languageCode, "-_"); i >= 0 {
+		primary = languageCode[:i]
+	}
+	if primary = strings.ToLower(primary); isSupportedLanguage(primary) {
+		return primary
+	}
+	return fallbackLanguage
+}
+
+// userLanguage prefers language chosen by user over the one of Telegram client
+func userLanguage(userInfo model.UserInfo, upd telegram.Update) string {
+	if isSupportedLanguage(userInfo.Language) {
+		return userInfo.Language
+	}
+	if lang := detectLanguage(getSender(upd).LanguageCode); lang != "" {
+		return lang
+	}
+	return defaultLanguage
+}
+
+func getSender(upd telegram.Update) telegram.User {
+	if upd.CallbackQuery.From.Id != 0 {
+		return upd.CallbackQuery.From
+	}
+	return upd.Message.From
+}
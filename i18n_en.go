@@ -0,0 +1,127 @@
+package main
+
+var enCatalog = catalog{
+	"lang.name":        "English",
+	"lang.choose":      "Choose language",
+	"lang.set":         "Language: English",
+	"lang.unsupported": "Supported languages: ru, en",
+
+	"error":     "Error",
+	"cancelled": "Cancelled",
+	"usage":     "Usage: %v",
+
+	"help.reg":         "Connect personal account",
+	"help.receipt":     "Download receipt in pdf",
+	"help.get":         "get debt information",
+	"help.history":     "charges history",
+	"help.notify":      "turn on debt notifications",
+	"help.unsubscribe": "turn off notifications",
+	"help.alert":       "choose what to notify about",
+	"help.remind":      "remind to pay",
+	"help.timezone":    "time zone for notifications",
+	"help.quiet":       "do not disturb at night",
+	"help.cancel":      "cancel input",
+	"help.logout":      "delete all your data",
+	"help.lang":        "change language",
+
+	"arg.login":      "login",
+	"arg.password":   "password",
+	"arg.account":    "account",
+	"arg.months":     "months",
+	"arg.action":     "action",
+	"arg.language":   "language",
+	"arg.rule":       "rule",
+	"arg.amount":     "amount",
+	"arg.dueDay":     "due day",
+	"arg.daysBefore": "days before",
+	"arg.timeZone":   "time zone",
+	"arg.from":       "from",
+	"arg.to":         "to",
+
+	"login.required":       "Connect your personal account: /reg",
+	"accounts.unavailable": "Unable to get the list of accounts, try again later",
+	"account.notFound":     "Account %v is not found among the ones connected in your personal account",
+	"account.choose":       "Which account do you want to %v?",
+
+	"op.get":         "get the balance of",
+	"op.receipt":     "get the receipt for",
+	"op.history":     "view the history of",
+	"op.notify":      "set up notifications for",
+	"op.unsubscribe": "turn off notifications for",
+	"op.alert":       "choose what to notify about for",
+	"op.other":       "use",
+
+	"reg.askLogin":         "Enter login of your personal account (/cancel – cancel)",
+	"reg.emptyLogin":       "Enter login of your personal account",
+	"reg.askPassword":      "Enter password. The message with password will be deleted (/cancel – cancel)",
+	"reg.wrongCredentials": "Wrong login or password. Try again: /reg",
+	"reg.failed":           "Unable to connect personal account, try again later",
+	"reg.done": "You have been registered. Found %d account: %v|" +
+		"You have been registered. Found %d accounts: %v",
+	"cancel.nothing": "Nothing to cancel",
+
+	"receipt.failed":  "Unable to download receipt",
+	"receipt.caption": "Receipt (%v)",
+
+	"notify.subscribed":    "You are subscribed to notifications for account %v (%v)",
+	"notify.notSubscribed": "You are not subscribed to notifications for account %v",
+	"notify.unsubscribed":  "Notifications for account %v are turned off",
+	"notify.reRegister": "Unable to log in to your personal account with the saved login and password. " +
+		"Notifications are paused. To resume them, connect your personal account again: /reg",
+
+	"alert.menu":               "Notifications for account %v are sent when:",
+	"alert.anyChange":          "the balance changes",
+	"alert.totalAbove":         "total due exceeds %v",
+	"alert.newBill":            "a new bill arrives",
+	"alert.debtIncrease":       "debt grows by more than %v",
+	"alert.totalAboveButton":   "Total due exceeds…",
+	"alert.debtIncreaseButton": "Debt grows by more than…",
+	"alert.anyButton":          "Any balance change",
+	"alert.askAmount":          "Enter the amount in rubles (/cancel – cancel)",
+	"alert.badAmount":          "Amount must be a positive number",
+	"alert.badRule":            "Rule must be one of: total_above, new_bill, debt_increase, any",
+	"alert.notSubscribed":      "You are not subscribed to notifications for account %v. Subscribe: /notify %[1]v",
+	"alert.firedTotalAbove":    "⚠️ Total due %.2f exceeds %v",
+	"alert.firedDebtIncrease":  "⚠️ Debt grew by %.2f",
+
+	"remind.disabled": "Payment reminders are off. Choose the day of month the bill must be paid by " +
+		"or send /remind [due day] [days before]",
+	"remind.enabled": "I remind to pay %d day before the %d and every day after until the debt is paid|" +
+		"I remind to pay %d days before the %d and every day after until the debt is paid",
+	"remind.dueDayButton":     "By the %v",
+	"remind.daysBeforeButton": "%d day before|%d days before",
+	"remind.offButton":        "Turn off",
+	"remind.badDueDay":        "Due day must be a number from 1 to 31 or off",
+	"remind.badDaysBefore":    "Number of days must be from 0 to 27",
+	"remind.upcoming":         "Reminder: %[2].2f must be paid by %[1]v for account %[3]v (%[4]v)",
+	"remind.overdue":          "Payment was due %v, debt is %.2f for account %v (%v)",
+
+	"timezone.choose":      "Time zone: %v. Choose another one or send, e.g., /timezone Asia/Yekaterinburg or /timezone UTC+5",
+	"timezone.default":     "server time",
+	"timezone.set":         "Time zone: %v, it's %v now",
+	"timezone.unsupported": "Unknown time zone. Examples: Europe/Moscow, Asia/Yekaterinburg, UTC+5",
+
+	"quiet.disabled": "Quiet hours are off, notifications arrive any time. " +
+		"Choose when not to disturb you or send /quiet [from] [to]",
+	"quiet.enabled":   "Quiet hours: from %02d:00 to %02d:00 (%v). Notifications of this time arrive in one message when they end",
+	"quiet.offButton": "Turn off",
+	"quiet.badStart":  "Specify an hour from 0 to 23 or off",
+	"quiet.badHour":   "Hour must be a number from 0 to 23",
+
+	"diff.header":   "Balance updated:",
+	"diff.newMonth": "New billing period: %v",
+	"diff.added":    "%v: %v (new row)",
+	"diff.removed":  "%v: row removed (was %v)",
+
+	"history.failed":    "Unable to load history",
+	"history.empty":     "History of account %v is empty yet. It is filled on /get and on subscription checks.",
+	"history.badMonths": "Number of months must be a positive number",
+
+	"logout.failed": "Unable to delete your data, try again later",
+	"logout.done": "All your data is deleted, notifications are turned off. " +
+		"To connect personal account again: /reg",
+	"logout.confirm": "Login, password and all subscriptions will be deleted, notifications will stop. " +
+		"Continue?",
+	"logout.confirmButton": "Delete my data",
+	"logout.cancelButton":  "Cancel",
+}
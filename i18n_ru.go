@@ -0,0 +1,129 @@
+package main
+
+var ruCatalog = catalog{
+	"lang.name":        "Русский",
+	"lang.choose":      "Выберите язык",
+	"lang.set":         "Язык: русский",
+	"lang.unsupported": "Поддерживаются языки: ru, en",
+
+	"error":     "Ошибка",
+	"cancelled": "Отменено",
+	"usage":     "Использование: %v",
+
+	"help.reg":         "Подключить личный кабинет",
+	"help.receipt":     "Скачать квитанцию в pdf",
+	"help.get":         "получить информацию о задолженности",
+	"help.history":     "история начислений",
+	"help.notify":      "подключить уведомления о задолженности",
+	"help.unsubscribe": "отключить уведомления",
+	"help.alert":       "выбрать, о чем уведомлять",
+	"help.remind":      "напоминать об оплате",
+	"help.timezone":    "часовой пояс для уведомлений",
+	"help.quiet":       "не беспокоить ночью",
+	"help.cancel":      "отменить ввод",
+	"help.logout":      "удалить все данные о себе",
+	"help.lang":        "сменить язык",
+
+	"arg.login":      "логин",
+	"arg.password":   "пароль",
+	"arg.account":    "лицевой счет",
+	"arg.months":     "месяцев",
+	"arg.action":     "действие",
+	"arg.language":   "язык",
+	"arg.rule":       "правило",
+	"arg.amount":     "сумма",
+	"arg.dueDay":     "день оплаты",
+	"arg.daysBefore": "за сколько дней",
+	"arg.timeZone":   "часовой пояс",
+	"arg.from":       "с",
+	"arg.to":         "до",
+
+	"login.required":       "Подключите личный кабинет: /reg",
+	"accounts.unavailable": "Не удалось получить список лицевых счетов, попробуйте позже",
+	"account.notFound":     "Лицевой счет %v не найден среди подключенных в личном кабинете",
+	"account.choose":       "По какому лицевому счету вы хотите %v?",
+
+	"op.get":         "получить баланс",
+	"op.receipt":     "получить квитанцию",
+	"op.history":     "посмотреть историю",
+	"op.notify":      "настроить уведомления",
+	"op.unsubscribe": "отключить уведомления",
+	"op.alert":       "выбрать, о чем уведомлять",
+	"op.other":       "произвести операцию",
+
+	"reg.askLogin":         "Введите логин от личного кабинета (/cancel – отменить)",
+	"reg.emptyLogin":       "Введите логин от личного кабинета",
+	"reg.askPassword":      "Введите пароль. Сообщение с паролем будет удалено (/cancel – отменить)",
+	"reg.wrongCredentials": "Неверный логин или пароль. Попробуйте еще раз: /reg",
+	"reg.failed":           "Не удалось подключить личный кабинет, попробуйте позже",
+	"reg.done": "Личный кабинет подключен. Найден %d лицевой счет: %v|" +
+		"Личный кабинет подключен. Найдено %d лицевых счета: %v|" +
+		"Личный кабинет подключен. Найдено %d лицевых счетов: %v",
+	"cancel.nothing": "Нечего отменять",
+
+	"receipt.failed":  "Не удалось загрузить квитанцию",
+	"receipt.caption": "Квитанция (%v)",
+
+	"notify.subscribed":    "Вы подписаны на уведомления по лицевому счету %v (%v)",
+	"notify.notSubscribed": "Вы не подписаны на уведомления по лицевому счету %v",
+	"notify.unsubscribed":  "Уведомления по лицевому счету %v отключены",
+	"notify.reRegister": "Не удается войти в личный кабинет с сохраненными логином и паролем. " +
+		"Уведомления приостановлены. Чтобы возобновить их, подключите личный кабинет заново: /reg",
+
+	"alert.menu":               "Уведомления по лицевому счету %v приходят, когда:",
+	"alert.anyChange":          "баланс изменился",
+	"alert.totalAbove":         "сумма к оплате больше %v",
+	"alert.newBill":            "пришла новая квитанция",
+	"alert.debtIncrease":       "долг вырос больше чем на %v",
+	"alert.totalAboveButton":   "Сумма к оплате больше…",
+	"alert.debtIncreaseButton": "Долг вырос больше чем на…",
+	"alert.anyButton":          "Любое изменение баланса",
+	"alert.askAmount":          "Введите сумму в рублях (/cancel – отменить)",
+	"alert.badAmount":          "Сумма должна быть положительным числом",
+	"alert.badRule":            "Правило должно быть одним из: total_above, new_bill, debt_increase, any",
+	"alert.notSubscribed":      "Вы не подписаны на уведомления по лицевому счету %v. Подписаться: /notify %[1]v",
+	"alert.firedTotalAbove":    "⚠️ Сумма к оплате %.2f больше %v",
+	"alert.firedDebtIncrease":  "⚠️ Долг вырос на %.2f",
+
+	"remind.disabled": "Напоминания об оплате выключены. Выберите, до какого числа нужно оплачивать квитанцию, " +
+		"или отправьте /remind [день оплаты] [за сколько дней]",
+	"remind.enabled": "Напоминаю об оплате за %d день до %d числа и каждый день после, пока долг не погашен|" +
+		"Напоминаю об оплате за %d дня до %d числа и каждый день после, пока долг не погашен|" +
+		"Напоминаю об оплате за %d дней до %d числа и каждый день после, пока долг не погашен",
+	"remind.dueDayButton":     "До %v числа",
+	"remind.daysBeforeButton": "За %d день|За %d дня|За %d дней",
+	"remind.offButton":        "Выключить",
+	"remind.badDueDay":        "День оплаты должен быть числом от 1 до 31 или off",
+	"remind.badDaysBefore":    "Количество дней должно быть числом от 0 до 27",
+	"remind.upcoming":         "Напоминание: до %v нужно оплатить %.2f по лицевому счету %v (%v)",
+	"remind.overdue":          "Срок оплаты %v прошел, задолженность %.2f по лицевому счету %v (%v)",
+
+	"timezone.choose":      "Часовой пояс: %v. Выберите другой или отправьте, например, /timezone Asia/Yekaterinburg или /timezone UTC+5",
+	"timezone.default":     "время сервера",
+	"timezone.set":         "Часовой пояс: %v, сейчас %v",
+	"timezone.unsupported": "Неизвестный часовой пояс. Примеры: Europe/Moscow, Asia/Yekaterinburg, UTC+5",
+
+	"quiet.disabled": "Тихие часы выключены, уведомления приходят в любое время. " +
+		"Выберите, когда не беспокоить, или отправьте /quiet [с] [до]",
+	"quiet.enabled":   "Тихие часы: с %02d:00 до %02d:00 (%v). Уведомления за это время придут одним сообщением, когда они закончатся",
+	"quiet.offButton": "Выключить",
+	"quiet.badStart":  "Укажите час от 0 до 23 или off",
+	"quiet.badHour":   "Час должен быть числом от 0 до 23",
+
+	"diff.header":   "Баланс обновился:",
+	"diff.newMonth": "Новый расчетный период: %v",
+	"diff.added":    "%v: %v (новая строка)",
+	"diff.removed":  "%v: строка удалена (было %v)",
+
+	"history.failed":    "Не удалось загрузить историю",
+	"history.empty":     "История по лицевому счету %v пока пуста. Она пополняется при запросе /get и при проверке подписок.",
+	"history.badMonths": "Количество месяцев должно быть положительным числом",
+
+	"logout.failed": "Не удалось удалить данные, попробуйте позже",
+	"logout.done": "Все ваши данные удалены, уведомления отключены. " +
+		"Чтобы снова подключить личный кабинет: /reg",
+	"logout.confirm": "Логин, пароль и все подписки будут удалены, уведомления перестанут приходить. " +
+		"Продолжить?",
+	"logout.confirmButton": "Удалить мои данные",
+	"logout.cancelButton":  "Отмена",
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/minya/telegram"
+)
+
+func TestEveryKeyIsInEveryCatalog(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for otherLang, other := range catalogs {
+			for key := range other {
+				if _, ok := catalog[key]; !ok {
+					t.Errorf("%v from %v catalog is missing in %v", key, otherLang, lang)
+				}
+			}
+		}
+		if _, ok := pluralRules[lang]; !ok {
+			t.Errorf("No plural rule for %v", lang)
+		}
+	}
+}
+
+func TestPluralMessagesHaveAllForms(t *testing.T) {
+	forms := map[string]int{langRU: 3, langEN: 2}
+	for lang, catalog := range catalogs {
+		for key, template := range catalog {
+			count := len(strings.Split(template, pluralSeparator))
+			if count != 1 && count != forms[lang] {
+				t.Errorf("%v in %v catalog has %v plural forms, expected %v", key, lang, count, forms[lang])
+			}
+		}
+	}
+}
+
+// TestUsedKeysExist scans sources for message keys, so a typo doesn't slip to users
+func TestUsedKeysExist(t *testing.T) {
+	used := regexp.MustCompile(`(?:\.text|\.plural)\("([a-zA-Z.]+)"|(?:help|name|invalid):\s+"([a-z]+\.[a-zA-Z.]+)"`)
+	files, _ := filepath.Glob("*.go")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range used.FindAllStringSubmatch(string(source), -1) {
+			key := match[1] + match[2]
+			if _, ok := catalogs[defaultLanguage][key]; !ok {
+				t.Errorf("%v: unknown message %v", file, key)
+			}
+		}
+	}
+	for _, spec := range commands {
+		for _, arg := range spec.args {
+			if _, ok := catalogs[defaultLanguage][arg.name]; !ok {
+				t.Errorf("%v: unknown argument name %v", spec.name, arg.name)
+			}
+		}
+	}
+}
+
+func TestRussianPlural(t *testing.T) {
+	tr := newTranslator(langRU)
+	cases := map[int]string{
+		1: "Найден 1 лицевой счет", 21: "Найден 21 лицевой счет",
+		2: "Найдено 2 лицевых счета", 24: "Найдено 24 лицевых счета",
+		0: "Найдено 0 лицевых счетов", 5: "Найдено 5 лицевых счетов",
+		11: "Найдено 11 лицевых счетов", 12: "Найдено 12 лицевых счетов", 111: "Найдено 111 лицевых счетов",
+	}
+	for n, expected := range cases {
+		if got := tr.plural("reg.done", n, n, ""); !strings.Contains(got, expected) {
+			t.Errorf("%v: expected %v, but got %v", n, expected, got)
+		}
+	}
+}
+
+func TestEnglishPlural(t *testing.T) {
+	tr := newTranslator(langEN)
+	if got := tr.plural("reg.done", 1, 1, ""); !strings.Contains(got, "1 account:") {
+		t.Error("Unexpected singular: ", got)
+	}
+	if got := tr.plural("reg.done", 2, 2, ""); !strings.Contains(got, "2 accounts:") {
+		t.Error("Unexpected plural: ", got)
+	}
+}
+
+func TestUnknownMessageFallsBack(t *testing.T) {
+	if got := newTranslator("de").text("error"); got != "Ошибка" {
+		t.Error("Expected default language, but got ", got)
+	}
+	if got := newTranslator(langEN).text("no.such.key"); got != "no.such.key" {
+		t.Error("Expected key, but got ", got)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":      "",
+		"ru":    langRU,
+		"ru-RU": langRU,
+		"EN_us": langEN,
+		"en":    langEN,
+		"de":    langEN,
+		"-":     langEN,
+	}
+	for code, expected := range cases {
+		if got := detectLanguage(code); got != expected {
+			t.Errorf("%q: expected %q, but got %q", code, expected, got)
+		}
+	}
+}
+
+func TestLangCommandOverridesDetectedLanguage(t *testing.T) {
+	storage := createFakeStorage()
+	h := createHandler(storage, newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+
+	upd := makeMsgUpdate("/lang")
+	upd.Message.From.LanguageCode = "ru"
+	reply := h.handle(context.Background(), upd).(telegram.ReplyMessage)
+	buttons := reply.ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard[0]
+	if len(buttons) != len(languages) || buttons[1].CallbackData != "/lang en" {
+		t.Fatal("Expected language buttons, but got ", buttons)
+	}
+
+	callback := makeCallbackUpdate(buttons[1].CallbackData)
+	callback.CallbackQuery.From.LanguageCode = "ru"
+	reply = h.handle(context.Background(), callback).(telegram.ReplyMessage)
+	if reply.Text != "Language: English" || storage.userInfo.Language != langEN {
+		t.Error("Expected English, but got ", reply.Text, storage.userInfo.Language)
+	}
+
+	reply = h.handle(context.Background(), upd).(telegram.ReplyMessage)
+	if reply.Text != "Choose language" {
+		t.Error("Chosen language must win over detected one, but got ", reply.Text)
+	}
+}
+
+func TestLangCommandRejectsUnsupportedLanguage(t *testing.T) {
+	storage := createFakeStorage()
+	h := createHandler(storage, newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/lang de")).(telegram.ReplyMessage)
+
+	if !strings.Contains(reply.Text, "Использование: /lang [язык]") || storage.userInfo.Language != "" {
+		t.Error("Expected usage, but got ", reply.Text)
+	}
+}
+
+func TestHelpIsTranslated(t *testing.T) {
+	text := help(makeMsgUpdate("/help"), newTranslator(langEN)).Text
+
+	if !strings.Contains(text, "/history [account] [months] – charges history") {
+		t.Error("Expected English help, but got ", text)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+)
+
+// Command and notification outcomes
+const (
+	outcomeOK           = "ok"
+	outcomeError        = "error"
+	outcomeInvalid      = "invalid"
+	outcomeUnknown      = "unknown"
+	outcomeUnregistered = "unregistered"
+	outcomeNoAccount    = "no_account"
+	outcomeSuppressed   = "suppressed"
+	outcomeQueued       = "queued"
+)
+
+var (
+	monitoring = &metricsRegistry{}
+
+	commandsTotal = monitoring.counter("ercinfobot_commands_total",
+		"Handled commands by outcome.", "command", "outcome")
+	ercDuration = monitoring.histogram("ercinfobot_erc_request_duration_seconds",
+		"Duration of ERC personal cabinet requests.", []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}, "method")
+	ercErrors = monitoring.counter("ercinfobot_erc_errors_total",
+		"Failed ERC personal cabinet requests.", "method")
+	storageDuration = monitoring.histogram("ercinfobot_storage_request_duration_seconds",
+		"Duration of storage requests.", []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}, "backend", "operation")
+	storageErrors = monitoring.counter("ercinfobot_storage_errors_total",
+		"Failed storage requests.", "backend", "operation")
+	telegramRequests = monitoring.counter("ercinfobot_telegram_requests_total",
+		"Bot API requests by outcome.", "method", "outcome")
+	cycleDuration = monitoring.histogram("ercinfobot_notifier_cycle_duration_seconds",
+		"Duration of notifier cycles.", []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800})
+	usersChecked = monitoring.counter("ercinfobot_notifier_users_checked_total",
+		"Users checked by notifier.")
+	notifierERCCalls = monitoring.counter("ercinfobot_notifier_erc_calls_total",
+		"ERC client calls made by notifier.")
+	notifierERCLogins = monitoring.counter("ercinfobot_notifier_erc_logins_total",
+		"ERC logins made by notifier, erclib logs in on every call.")
+	notificationsSent = monitoring.counter("ercinfobot_notifications_sent_total",
+		"Balance change notifications by outcome.", "outcome")
+	remindersSent = monitoring.counter("ercinfobot_reminders_sent_total",
+		"Payment reminders by outcome.", "outcome")
+)
+
+func outcomeOf(err error) string {
+	if err != nil {
+		return outcomeError
+	}
+	return outcomeOK
+}
+
+// instrumentedERCClient measures ERC requests
+type instrumentedERCClient struct {
+	client ercclient
+}
+
+func (c instrumentedERCClient) GetAccounts() ([]erclib.Account, error) {
+	defer ercDuration.observeSince(time.Now(), "accounts")
+	accounts, err := c.client.GetAccounts()
+	countERCError("accounts", err)
+	return accounts, err
+}
+
+func (c instrumentedERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	defer ercDuration.observeSince(time.Now(), "balance")
+	balance, err := c.client.GetBalanceInfo(account, t)
+	countERCError("balance", err)
+	return balance, err
+}
+
+func (c instrumentedERCClient) GetReceipt(accNumber string) ([]byte, error) {
+	defer ercDuration.observeSince(time.Now(), "receipt")
+	receipt, err := c.client.GetReceipt(accNumber)
+	countERCError("receipt", err)
+	return receipt, err
+}
+
+func countERCError(method string, err error) {
+	if err != nil {
+		ercErrors.inc(method)
+	}
+}
+
+// instrumentedStorage measures storage requests and reports storage readiness
+type instrumentedStorage struct {
+	storage baseStorage
+	backend string
+	health  *readiness
+}
+
+func (s instrumentedStorage) GetUserInfo(ctx context.Context, userID int) (model.UserInfo, error) {
+	defer storageDuration.observeSince(time.Now(), s.backend, "get_user")
+	userInfo, err := s.storage.GetUserInfo(ctx, userID)
+	s.observe("get_user", err)
+	return userInfo, err
+}
+
+func (s instrumentedStorage) SaveUser(ctx context.Context, userID int, userInfo model.UserInfo) error {
+	defer storageDuration.observeSince(time.Now(), s.backend, "save_user")
+	err := s.storage.SaveUser(ctx, userID, userInfo)
+	s.observe("save_user", err)
+	return err
+}
+
+func (s instrumentedStorage) GetUsers(ctx context.Context) (map[int]model.UserInfo, error) {
+	defer storageDuration.observeSince(time.Now(), s.backend, "get_users")
+	users, err := s.storage.GetUsers(ctx)
+	s.observe("get_users", err)
+	return users, err
+}
+
+func (s instrumentedStorage) DeleteUser(ctx context.Context, userID int) error {
+	defer storageDuration.observeSince(time.Now(), s.backend, "delete_user")
+	err := s.storage.DeleteUser(ctx, userID)
+	s.observe("delete_user", err)
+	return err
+}
+
+func (s instrumentedStorage) AppendBalance(
+	ctx context.Context, userID int, account string, entry model.BalanceHistoryEntry) (bool, error) {
+	defer storageDuration.observeSince(time.Now(), s.backend, "append_balance")
+	appended, err := s.storage.AppendBalance(ctx, userID, account, entry)
+	s.observe("append_balance", err)
+	return appended, err
+}
+
+func (s instrumentedStorage) GetBalanceHistory(
+	ctx context.Context, userID int, account string) ([]model.BalanceHistoryEntry, error) {
+	defer storageDuration.observeSince(time.Now(), s.backend, "get_balance_history")
+	entries, err := s.storage.GetBalanceHistory(ctx, userID, account)
+	s.observe("get_balance_history", err)
+	return entries, err
+}
+
+// Close closes underlying storage if it needs closing
+func (s instrumentedStorage) Close() error {
+	if closer, ok := s.storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// observe counts failure, cancelled requests tell nothing about storage health
+func (s instrumentedStorage) observe(operation string, err error) {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if err != nil {
+		storageErrors.inc(s.backend, operation)
+	}
+	s.health.set(checkStorage, err)
+}
@@ -0,0 +1,301 @@
+// Package fakebotapi is an in-process Telegram Bot API for tests.
+// It serves the methods the bot uses, records every call and
+// delivers updates either to a registered webhook or via getUpdates.
+package fakebotapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/minya/telegram"
+)
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Call is a recorded Bot API request
+type Call struct {
+	Method string
+	Params map[string]interface{}
+}
+
+// Document is a file uploaded with sendDocument
+type Document struct {
+	ChatID      int
+	Caption     string
+	FileName    string
+	Content     []byte
+	ReplyMarkup string
+}
+
+// Webhook is what the bot passed to setWebhook
+type Webhook struct {
+	URL         string
+	SecretToken string
+}
+
+// Server emulates Bot API for a single bot token
+type Server struct {
+	Token string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	calls    []Call
+	messages []telegram.ReplyMessage
+	docs     []Document
+	deleted  []int
+	webhook  Webhook
+	updates  []telegram.Update
+	nextID   int
+	failures map[string]int
+}
+
+// New starts server, it is closed with Close
+func New(token string) *Server {
+	s := &Server{Token: token, nextID: 1, failures: make(map[string]int)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL is a base URL to use instead of https://api.telegram.org
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Close stops server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// FailNext makes the next count calls of method fail with 502
+func (s *Server) FailNext(method string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] += count
+}
+
+// PushUpdate queues update for getUpdates and returns it with update_id assigned
+func (s *Server) PushUpdate(upd telegram.Update) telegram.Update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upd.UpdateId = s.nextID
+	s.nextID++
+	s.updates = append(s.updates, upd)
+	return upd
+}
+
+// Deliver posts update to the registered webhook like Telegram does and returns response status
+func (s *Server) Deliver(upd telegram.Update) (int, error) {
+	s.mu.Lock()
+	webhook := s.webhook
+	upd.UpdateId = s.nextID
+	s.nextID++
+	s.mu.Unlock()
+
+	if webhook.URL == "" {
+		return 0, fmt.Errorf("Webhook is not set")
+	}
+	body, err := json.Marshal(upd)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if webhook.SecretToken != "" {
+		request.Header.Set(secretTokenHeader, webhook.SecretToken)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.StatusCode, nil
+}
+
+// Calls returns every recorded call in order
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// Messages returns sent messages
+func (s *Server) Messages() []telegram.ReplyMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]telegram.ReplyMessage{}, s.messages...)
+}
+
+// Documents returns uploaded documents
+func (s *Server) Documents() []Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Document{}, s.docs...)
+}
+
+// DeletedMessages returns ids of deleted messages
+func (s *Server) DeletedMessages() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int{}, s.deleted...)
+}
+
+// SetWebhook registers webhook the way it's done with setWebhook outside the bot
+func (s *Server) SetWebhook(webhook Webhook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhook = webhook
+}
+
+// Webhook returns the registered webhook
+func (s *Server) Webhook() Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.webhook
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	prefix := "/bot" + s.Token + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		reply(w, http.StatusUnauthorized, false, "Unauthorized", nil)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, prefix)
+
+	params, doc, err := readParams(r)
+	if err != nil {
+		reply(w, http.StatusBadRequest, false, err.Error(), nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Params: params})
+	if s.failures[method] > 0 {
+		s.failures[method]--
+		reply(w, http.StatusBadGateway, false, "Bad Gateway", nil)
+		return
+	}
+
+	var result interface{} = true
+	switch method {
+	case "sendMessage":
+		var msg telegram.ReplyMessage
+		if err = remarshal(params, &msg); err != nil {
+			reply(w, http.StatusBadRequest, false, err.Error(), nil)
+			return
+		}
+		s.messages = append(s.messages, msg)
+		result = map[string]interface{}{"message_id": len(s.messages), "chat": map[string]int{"id": msg.ChatId}}
+	case "sendDocument":
+		if doc == nil {
+			reply(w, http.StatusBadRequest, false, "Bad Request: there is no document in the request", nil)
+			return
+		}
+		s.docs = append(s.docs, *doc)
+	case "deleteMessage":
+		s.deleted = append(s.deleted, toInt(params["message_id"]))
+	case "setWebhook":
+		s.webhook = Webhook{URL: fmt.Sprint(params["url"])}
+		if token, ok := params["secret_token"]; ok {
+			s.webhook.SecretToken = fmt.Sprint(token)
+		}
+	case "deleteWebhook":
+		s.webhook = Webhook{}
+	case "getUpdates":
+		offset := toInt(params["offset"])
+		pending := []telegram.Update{}
+		for _, upd := range s.updates {
+			if upd.UpdateId >= offset {
+				pending = append(pending, upd)
+			}
+		}
+		result = pending
+	default:
+		reply(w, http.StatusNotFound, false, "Not Found: method not found", nil)
+		return
+	}
+	reply(w, http.StatusOK, true, "", result)
+}
+
+// readParams reads JSON or multipart/form-data parameters, the latter may carry a document
+func readParams(r *http.Request) (map[string]interface{}, *Document, error) {
+	params := make(map[string]interface{})
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || len(body) == 0 {
+			return params, nil, err
+		}
+		return params, nil, json.Unmarshal(body, &params)
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, nil, err
+	}
+	for key, values := range r.MultipartForm.Value {
+		params[key] = values[0]
+	}
+	files := r.MultipartForm.File["document"]
+	if len(files) == 0 {
+		return params, nil, nil
+	}
+	file, err := files[0].Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, &Document{
+		ChatID:      toInt(params["chat_id"]),
+		Caption:     fmt.Sprint(params["caption"]),
+		FileName:    files[0].Filename,
+		Content:     content,
+		ReplyMarkup: fmt.Sprint(params["reply_markup"]),
+	}, nil
+}
+
+func reply(w http.ResponseWriter, status int, ok bool, description string, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := map[string]interface{}{"ok": ok}
+	if description != "" {
+		response["error_code"] = status
+		response["description"] = description
+	}
+	if result != nil {
+		response["result"] = result
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func remarshal(params map[string]interface{}, target interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+// setLanguage saves language chosen by user or offers supported ones
+func (h *handler) setLanguage(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, args []string, tr translator) interface{} {
+	if len(args) == 0 || args[0] == "" {
+		return telegram.ReplyMessage{
+			ChatId:      getReplyToChatID(upd),
+			Text:        tr.text("lang.choose"),
+			ReplyMarkup: chooseLanguageButtons(),
+		}
+	}
+
+	userInfo.Language = args[0]
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return replyWithMessage(upd, newTranslator(userInfo.Language).text("lang.set"))
+}
+
+func chooseLanguageButtons() telegram.InlineKeyboardMarkup {
+	row := make([]telegram.InlineKeyboardButton, 0, len(languages))
+	for _, lang := range languages {
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         newTranslator(lang).text("lang.name"),
+			CallbackData: "/lang " + lang,
+		})
+	}
+	return telegram.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegram.InlineKeyboardButton{row},
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level logLevel) String() string {
+	return levelNames[level]
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	if name == "" {
+		return levelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return logLevel(level), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("Unknown log level %v", name)
+}
+
+// Log formats
+const (
+	formatLogfmt = "logfmt"
+	formatJSON   = "json"
+)
+
+// logSink writes entries of every logger derived from the same root
+type logSink struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  logLevel
+	json   bool
+	now    func() time.Time
+	failed bool
+}
+
+// logger writes leveled entries with fields, e.g.
+// time=2024-01-02T03:04:05Z level=info msg="Balance changed" check=1f2e3d4c user=42 account=1234567
+type logger struct {
+	sink   *logSink
+	fields []interface{}
+}
+
+func newLogger(out io.Writer, level logLevel, format string) logger {
+	return logger{sink: &logSink{out: out, level: level, json: format == formatJSON, now: time.Now}}
+}
+
+// rootLogger is used when context carries no logger, main replaces it with a configured one
+var rootLogger = newLogger(os.Stderr, levelInfo, formatLogfmt)
+
+// with returns logger adding key-value pairs to every entry
+func (l logger) with(keyvals ...interface{}) logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	return logger{sink: l.sink, fields: append(append(fields, l.fields...), keyvals...)}
+}
+
+func (l logger) debugf(format string, args ...interface{}) { l.log(levelDebug, format, args) }
+func (l logger) infof(format string, args ...interface{})  { l.log(levelInfo, format, args) }
+func (l logger) warnf(format string, args ...interface{})  { l.log(levelWarn, format, args) }
+func (l logger) errorf(format string, args ...interface{}) { l.log(levelError, format, args) }
+
+// fatalf logs error and exits
+func (l logger) fatalf(format string, args ...interface{}) {
+	l.log(levelError, format, args)
+	os.Exit(1)
+}
+
+func (l logger) log(level logLevel, format string, args []interface{}) {
+	if level < l.sink.level {
+		return
+	}
+	keyvals := append([]interface{}{
+		"time", l.sink.now().UTC().Format(time.RFC3339Nano),
+		"level", level,
+		"msg", fmt.Sprintf(format, args...),
+	}, l.fields...)
+
+	var entry []byte
+	if l.sink.json {
+		entry = encodeJSON(keyvals)
+	} else {
+		entry = encodeLogfmt(keyvals)
+	}
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	if _, err := l.sink.out.Write(entry); err != nil && !l.sink.failed {
+		// the log itself is broken, tell it once where it can still be seen
+		l.sink.failed = true
+		fmt.Fprintf(os.Stderr, "Unable to write log: %v\n", err)
+	}
+}
+
+func encodeLogfmt(keyvals []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(keyvals[i]))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(valueAt(keyvals, i+1)))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func logfmtValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" {
+		return `""`
+	}
+	if strings.IndexFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+func encodeJSON(keyvals []interface{}) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(jsonValue(valueAt(keyvals, i+1)))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// jsonValue keeps numbers and booleans, everything else is written as its text
+func jsonValue(value interface{}) []byte {
+	switch value.(type) {
+	case int, int64, float64, bool:
+		encoded, _ := json.Marshal(value)
+		return encoded
+	}
+	encoded, _ := json.Marshal(fmt.Sprint(value))
+	return encoded
+}
+
+func valueAt(keyvals []interface{}, i int) interface{} {
+	if i < len(keyvals) {
+		return keyvals[i]
+	}
+	return "(missing)"
+}
+
+type loggerKey struct{}
+
+// withLogger returns ctx carrying l, work done with ctx logs with l's fields
+func withLogger(ctx context.Context, l logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// loggerFrom returns logger of ctx or the root one
+func loggerFrom(ctx context.Context) logger {
+	if l, ok := ctx.Value(loggerKey{}).(logger); ok {
+		return l
+	}
+	return rootLogger
+}
+
+// withLogFields returns ctx whose logger adds key-value pairs
+func withLogFields(ctx context.Context, keyvals ...interface{}) context.Context {
+	return withLogger(ctx, loggerFrom(ctx).with(keyvals...))
+}
+
+// newCorrelationID makes a short random ID to find all entries about one update or check
+func newCorrelationID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// stdLogWriter turns lines of the standard logger (used by dependencies) into entries
+type stdLogWriter struct {
+	logger logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.infof("%v", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// modelLogger writes entries of model's storages with the logger of their ctx
+type modelLogger struct{}
+
+func (modelLogger) Info(ctx context.Context, msg string, keyvals ...interface{}) {
+	loggerFrom(ctx).with(keyvals...).infof("%v", msg)
+}
+
+func (modelLogger) Error(ctx context.Context, msg string, keyvals ...interface{}) {
+	loggerFrom(ctx).with(keyvals...).errorf("%v", msg)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogfmtEntry(t *testing.T) {
+	var buf bytes.Buffer
+	logger := createTestLogger(&buf, levelInfo, formatLogfmt)
+
+	logger.with("user", 42, "command", "/get").infof("Balance is %v", "100 руб")
+
+	expected := `time=2024-01-02T03:04:05Z level=info msg="Balance is 100 руб" user=42 command=/get` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, buf.String())
+	}
+}
+
+func TestJSONEntry(t *testing.T) {
+	var buf bytes.Buffer
+	logger := createTestLogger(&buf, levelInfo, formatJSON)
+
+	logger.with("user", 42, "account", "123", "odd").warnf(`say "hi"`)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal("Entry is not JSON: ", buf.String())
+	}
+	if entry["level"] != "warn" || entry["msg"] != `say "hi"` || entry["user"] != 42.0 ||
+		entry["account"] != "123" || entry["odd"] != "(missing)" {
+		t.Error("Unexpected entry: ", buf.String())
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	var buf bytes.Buffer
+	logger := createTestLogger(&buf, levelWarn, formatLogfmt)
+
+	logger.debugf("debug")
+	logger.infof("info")
+	logger.warnf("warn")
+	logger.errorf("error")
+
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 || strings.Contains(buf.String(), "level=info") {
+		t.Error("Expected warn and error only, but got ", buf.String())
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	cases := map[string]logLevel{"": levelInfo, "debug": levelDebug, "WARN": levelWarn, "error": levelError}
+	for name, expected := range cases {
+		if level, err := parseLogLevel(name); err != nil || level != expected {
+			t.Errorf("%q: expected %v, but got %v %v", name, expected, level, err)
+		}
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
+
+func TestLogSettingsValidation(t *testing.T) {
+	valid := []LogSettings{{}, {Level: "debug", Format: formatJSON}, {Format: formatLogfmt, Path: logToStdout}}
+	for _, settings := range valid {
+		if !settings.areValid() {
+			t.Error("Expected valid: ", settings)
+		}
+	}
+	invalid := []LogSettings{{Level: "verbose"}, {Format: "xml"}}
+	for _, settings := range invalid {
+		if settings.areValid() {
+			t.Error("Expected invalid: ", settings)
+		}
+	}
+}
+
+func TestContextCarriesFieldsThroughDetach(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := withLogger(context.Background(), createTestLogger(&buf, levelInfo, formatLogfmt))
+	ctx = withLogFields(detach(withLogFields(ctx, "update", 7)), "user", 42)
+
+	loggerFrom(ctx).infof("done")
+
+	if !strings.Contains(buf.String(), "update=7 user=42") {
+		t.Error("Expected fields of every layer, but got ", buf.String())
+	}
+}
+
+func TestUpdateEntriesShareCorrelationID(t *testing.T) {
+	logged := captureLog(t)
+	h := createHandler(createFakeStorage(), newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+
+	processUpdate(context.Background(), h.handle, &fakeReplySender{}, makeMsgUpdate("/get"))
+
+	var ids []string
+	for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+		if !strings.Contains(line, "update=431") {
+			continue
+		}
+		ids = append(ids, fieldValue(line, "correlation"))
+		if strings.Contains(line, "Process command") &&
+			(!strings.Contains(line, "user=100500") || !strings.Contains(line, "command=/get")) {
+			t.Error("Expected user and command fields: ", line)
+		}
+	}
+	if len(ids) < 2 {
+		t.Fatal("Expected several entries about the update, but got ", logged.String())
+	}
+	for _, id := range ids {
+		if id == "" || id != ids[0] {
+			t.Error("Expected the same correlation ID, but got ", ids)
+		}
+	}
+}
+
+func TestStdLogIsStructured(t *testing.T) {
+	var buf bytes.Buffer
+	std := log.New(stdLogWriter{logger: createTestLogger(&buf, levelInfo, formatLogfmt).with("source", "stdlog")}, "", 0)
+
+	std.Printf("Signing in to firebase as %v\n", "bot@example.com")
+
+	expected := `time=2024-01-02T03:04:05Z level=info msg="Signing in to firebase as bot@example.com" source=stdlog` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, buf.String())
+	}
+}
+
+func createTestLogger(buf *bytes.Buffer, level logLevel, format string) logger {
+	logger := newLogger(buf, level, format)
+	logger.sink.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return logger
+}
+
+func fieldValue(line string, key string) string {
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, key+"=") {
+			return strings.TrimPrefix(field, key+"=")
+		}
+	}
+	return ""
+}
+
+func TestModelEntriesKeepLevelAndFields(t *testing.T) {
+	buf := captureLog(t)
+	ctx := withLogFields(context.Background(), "check", "1f2e3d4c")
+
+	modelLogger{}.Error(ctx, "Unable to decrypt credentials: Unknown encryption key", "user", 42)
+
+	entry := buf.String()
+	if !strings.Contains(entry, "level=error") || !strings.Contains(entry, "check=1f2e3d4c user=42") {
+		t.Error("Unexpected entry: ", entry)
+	}
+}
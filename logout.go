@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"github.com/minya/telegram"
+)
+
+const (
+	logoutConfirm = "confirm"
+	logoutCancel  = "cancel"
+)
+
+// logout asks for confirmation and then wipes everything stored about user
+func (h *handler) logout(ctx context.Context, upd telegram.Update, userID int, args []string, tr translator) interface{} {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case logoutConfirm:
+		if err := h.storage.DeleteUser(ctx, userID); err != nil {
+			loggerFrom(ctx).errorf("Error while deleting user: %v", err)
+			return replyWithMessage(upd, tr.text("logout.failed"))
+		}
+		loggerFrom(ctx).infof("User logged out")
+		return telegram.ReplyMessage{
+			ChatId: getReplyToChatID(upd),
+			Text:   tr.text("logout.done"),
+		}
+	case logoutCancel:
+		return replyWithMessage(upd, tr.text("cancelled"))
+	}
+
+	return telegram.ReplyMessage{
+		ChatId: getReplyToChatID(upd),
+		Text:   tr.text("logout.confirm"),
+		ReplyMarkup: telegram.InlineKeyboardMarkup{
+			InlineKeyboard: [][]telegram.InlineKeyboardButton{
+				{
+					{Text: tr.text("logout.confirmButton"), CallbackData: "/logout " + logoutConfirm},
+					{Text: tr.text("logout.cancelButton"), CallbackData: "/logout " + logoutCancel},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/minya/telegram"
+)
+
+func TestLogoutAsksForConfirmation(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/logout")).(telegram.ReplyMessage)
+
+	buttons := reply.ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard[0]
+	if buttons[0].CallbackData != "/logout confirm" || buttons[1].CallbackData != "/logout cancel" {
+		t.Error("Unexpected buttons: ", buttons)
+	}
+	if len(storage.deleted) != 0 {
+		t.Error("User must not be deleted before confirmation")
+	}
+}
+
+func TestLogoutConfirmDeletesUser(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeCallbackUpdate("/logout confirm")).(telegram.ReplyMessage)
+
+	if len(storage.deleted) != 1 || storage.deleted[0] != userID {
+		t.Error("Expected user to be deleted, but deleted ", storage.deleted)
+	}
+	if reply.ChatId != chatID {
+		t.Error("Reply chat mismatch: ", reply.ChatId)
+	}
+}
+
+func TestLogoutCancelKeepsUser(t *testing.T) {
+	storage := createFakeStorageWithSubscriptions("account_0")
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	h.handle(context.Background(), makeCallbackUpdate("/logout cancel"))
+
+	if len(storage.deleted) != 0 || storage.userInfo.Login == "" {
+		t.Error("User must not be deleted")
+	}
+}
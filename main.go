@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/minya/erc/erclib"
@@ -12,64 +22,234 @@ import (
 	"github.com/minya/telegram"
 )
 
+var reEncrypt = flag.Bool("reencrypt", false, "Re-encrypt stored credentials with the current key and exit")
+
 func main() {
-	settings, storage, updateCheckPeriod := initialize()
-	ntf := notifier{botToken: settings.ID, storage: storage, sleepDuration: updateCheckPeriod}
-	botApi := telegram.NewApi(settings.ID)
-	ntf.Start(&botApi)
+	ready := newReadiness(checkStorage, checkListener)
+	settings, storage, history, updateCheckPeriod := initialize(ready)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *reEncrypt {
+		reEncryptCredentials(ctx, storage)
+		return
+	}
+	// erclib.ErcClient logs in on every call: its session is cached
+	// in a copy of the client since methods have value receivers
 	var makeERCClient = func(l string, p string) ercclient {
-		return erclib.NewErcClientWithCredentials(l, p)
+		return instrumentedERCClient{client: erclib.NewErcClientWithCredentials(l, p)}
+	}
+	bot := newBotAPI(settings.ID)
+	monitoringServer := &http.Server{
+		Addr:    fmt.Sprintf(":%v", settings.Monitoring.port()),
+		Handler: newMonitoringHandler(monitoring, ready),
+	}
+	go func() {
+		rootLogger.infof("Serve metrics and health probes on %v", monitoringServer.Addr)
+		if err := monitoringServer.ListenAndServe(); err != http.ErrServerClosed {
+			rootLogger.warnf("Unable to serve metrics: %v", err)
+		}
+	}()
+
+	ntf := createNotifier(storage, history, makeERCClient, bot, updateCheckPeriod, settings.Notifier)
+	running := []<-chan struct{}{runInBackground(func() { ntf.Run(ctx) })}
+
+	h := createHandler(storage, history, makeERCClient, bot)
+	var server *http.Server
+	if settings.Transport == transportPolling {
+		updatesPoller := createPoller(bot, h.handle, settings.Polling, ready)
+		running = append(running, runInBackground(func() { updatesPoller.Run(ctx) }))
+	} else {
+		webhook := settings.Webhook
+		if webhook.SecretToken == "" {
+			rootLogger.warnf("Webhook secret token is not configured, updates are not authenticated")
+		}
+		server = &http.Server{
+			Addr: fmt.Sprintf(":%v", webhook.port()),
+			Handler: updatesServer{
+				handle:      h.handle,
+				sender:      bot,
+				path:        webhook.path(),
+				secretToken: webhook.SecretToken,
+			},
+		}
+		go func() {
+			rootLogger.infof("Listen on %v%v", server.Addr, webhook.path())
+			listener, err := net.Listen("tcp", server.Addr)
+			if err == nil {
+				ready.set(checkListener, nil)
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				rootLogger.errorf("Unable to start listen: %v", err)
+				ready.set(checkListener, err)
+				stop()
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	// a repeated signal kills the process without waiting
+	stop()
+	ready.set(checkListener, errShuttingDown)
+	shutdown(server, running, history, settings.shutdownTimeout())
+	monitoringServer.Close()
+}
+
+// runInBackground starts f in a goroutine, returned channel is closed when f returns
+func runInBackground(f func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	return done
+}
+
+// shutdown stops accepting updates and waits up to timeout for in-flight updates and checks.
+// Storage is closed only if everything is finished, otherwise the process just exits.
+func shutdown(server *http.Server, running []<-chan struct{}, storage interface{}, timeout time.Duration) {
+	rootLogger.infof("Shutting down, waiting up to %v", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			rootLogger.warnf("Updates are not finished: %v", err)
+			return
+		}
+	}
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			rootLogger.warnf("Work is not finished in %v", timeout)
+			return
+		}
 	}
-	h := createHandler(storage, makeERCClient)
-	listenErr := telegram.StartListen(settings.ID, 8080, h.handle)
-	if nil != listenErr {
-		log.Printf("Unable to start listen: %v\n", listenErr)
+	if closer, ok := storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			rootLogger.errorf("Unable to close storage: %v", err)
+		}
 	}
+	rootLogger.infof("Stopped")
 }
 
-func initialize() (BotSettings, model.FirebaseStorage, time.Duration) {
+func initialize(ready *readiness) (BotSettings, model.UserStorage, model.HistoryStorage, time.Duration) {
 	var settings BotSettings
-	var storage model.FirebaseStorage
 	var updateCheckPeriod time.Duration
 	var logPath string
 	flag.StringVar(&logPath, "logpath", "ercInfoBot.log", "Path to write logs")
 	var configPath string
 	flag.StringVar(&configPath, "cfg", "~/.ercInfoBot/settings.json", "Path to write logs")
 	flag.Parse()
-	setUpLogger(logPath)
 
 	errCfg := config.UnmarshalJson(&settings, configPath)
 
 	if nil != errCfg {
 		panic("Unable to get config")
 	}
-	log.Printf("Config read: %v\n", settings)
+	setUpLogger(settings.Log, logPath, settings.secrets())
+	rootLogger.infof("Config read: %v", settings)
 
 	var errParseDuration error
 	updateCheckPeriod, errParseDuration = time.ParseDuration(settings.UpdateCheckPeriod)
 	if errParseDuration != nil {
-		log.Fatalf("Unable to parse duration from '%v' \n", settings.UpdateCheckPeriod)
+		rootLogger.fatalf("Unable to parse duration from '%v'", settings.UpdateCheckPeriod)
 	}
 	if !settings.areValid() {
-		log.Fatalf("Incorrect settings: %v\n", settings)
-		panic("Incorrect settings")
+		rootLogger.fatalf("Incorrect settings: %v", settings)
+	}
+
+	storage, history, errStorage := createStorage(settings, ready)
+	if errStorage != nil {
+		rootLogger.fatalf("Unable to open storage: %v", errStorage)
 	}
+	return settings, storage, history, updateCheckPeriod
+}
+
+// baseStorage is implemented by every storage backend
+type baseStorage interface {
+	model.UserStorage
+	model.HistoryStorage
+}
+
+func createStorage(settings BotSettings, ready *readiness) (model.UserStorage, model.HistoryStorage, error) {
+	base, err := createBaseStorage(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	storage := instrumentedStorage{storage: base, backend: settings.storageBackend(), health: ready}
+	keys, err := loadKeyring(settings.Encryption)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keys == nil {
+		rootLogger.warnf("Encryption key is not configured, credentials are stored as is")
+		return storage, storage, nil
+	}
+	return model.NewEncryptedStorage(storage, *keys, modelLogger{}), storage, nil
+}
 
+func createBaseStorage(settings BotSettings) (baseStorage, error) {
+	if settings.Storage == storageBolt {
+		return model.NewBoltStorage(settings.BoltSettings.Path)
+	}
 	fbSettings := settings.StorageSettings
-	storage = model.NewFirebaseStorage(
+	return model.NewFirebaseStorage(
 		fbSettings.BaseURL,
 		fbSettings.APIKey,
 		fbSettings.Login,
-		fbSettings.Password)
-	return settings, storage, updateCheckPeriod
+		fbSettings.Password,
+		modelLogger{}), nil
 }
 
-func setUpLogger(logPath string) {
-	logFile, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+func loadKeyring(settings EncryptionSettings) (*model.Keyring, error) {
+	key := settings.Key
+	if key == "" && settings.KeyFile != "" {
+		keyBytes, err := ioutil.ReadFile(settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read key file: %v", err)
+		}
+		key = string(keyBytes)
+	}
+	if key == "" {
+		return nil, nil
+	}
+	keys, err := model.NewKeyring(key, settings.PreviousKeys...)
 	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+		return nil, err
 	}
-	log.SetOutput(logFile)
+	return &keys, nil
+}
+
+func reEncryptCredentials(ctx context.Context, storage model.UserStorage) {
+	encrypted, ok := storage.(model.EncryptedStorage)
+	if !ok {
+		rootLogger.fatalf("Unable to re-encrypt: encryption key is not configured")
+	}
+	count, err := encrypted.ReEncryptAll(ctx)
+	if err != nil {
+		rootLogger.fatalf("Re-encryption failed after %v users: %v", count, err)
+	}
+	rootLogger.infof("Re-encrypted credentials of %v users", count)
+}
+
+// setUpLogger makes root logger writing to the configured output with secrets masked.
+// The standard logger, which dependencies use, is routed through it too.
+func setUpLogger(settings LogSettings, defaultPath string, secrets []string) {
+	var out io.Writer = os.Stdout
+	if path := settings.path(defaultPath); path != logToStdout {
+		logFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			rootLogger.fatalf("Unable to open log file: %v", err)
+		}
+		out = logFile
+	}
+	writer := newRedactingWriter(out)
+	writer.addSecrets(secrets...)
+	level, _ := parseLogLevel(settings.Level)
+	rootLogger = newLogger(writer, level, settings.format())
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{logger: rootLogger.with("source", "stdlog")})
 }
 
 func replyButtons() telegram.ReplyKeyboardMarkup {
@@ -84,21 +264,102 @@ func replyButtons() telegram.ReplyKeyboardMarkup {
 	}
 }
 
+const (
+	storageFirebase = "firebase"
+	storageBolt     = "bolt"
+
+	transportWebhook = "webhook"
+	transportPolling = "polling"
+)
+
 // BotSettings struct to represent stored settings
+// Storage selects users storage backend: "firebase" (default) or "bolt"
+// Transport selects how updates are received: "webhook" (default) or "polling"
+// ShutdownTimeout limits waiting for in-flight work on SIGTERM (e.g. "30s")
 type BotSettings struct {
-	ID                string           `json:"id"`
-	UpdateCheckPeriod string           `json:"updateCheckPeriod"`
-	StorageSettings   FirebaseSettings `json:"storageSettings"`
+	ID                string             `json:"id"`
+	UpdateCheckPeriod string             `json:"updateCheckPeriod"`
+	Storage           string             `json:"storage,omitempty"`
+	StorageSettings   FirebaseSettings   `json:"storageSettings"`
+	BoltSettings      BoltSettings       `json:"boltSettings"`
+	Encryption        EncryptionSettings `json:"encryption"`
+	Notifier          NotifierSettings   `json:"notifier"`
+	ShutdownTimeout   string             `json:"shutdownTimeout,omitempty"`
+	Transport         string             `json:"transport,omitempty"`
+	Polling           PollingSettings    `json:"polling"`
+	Webhook           WebhookSettings    `json:"webhook"`
+	Monitoring        MonitoringSettings `json:"monitoring"`
+	Log               LogSettings        `json:"log"`
+}
+
+const defaultShutdownTimeout = 30 * time.Second
+
+func (theSettings BotSettings) shutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(theSettings.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		return defaultShutdownTimeout
+	}
+	return timeout
 }
 
 func (theSettings BotSettings) areValid() bool {
-	fbSettings := &theSettings.StorageSettings
 	return theSettings.ID != "" &&
-		fbSettings.APIKey != "" &&
-		fbSettings.BaseURL != "" &&
-		fbSettings.Login != "" &&
-		fbSettings.Password != "" &&
-		theSettings.UpdateCheckPeriod != ""
+		theSettings.UpdateCheckPeriod != "" &&
+		theSettings.storageSettingsAreValid() &&
+		theSettings.transportIsValid() &&
+		theSettings.Webhook.areValid() &&
+		theSettings.Monitoring.areValid() &&
+		theSettings.Log.areValid()
+}
+
+func (theSettings BotSettings) transportIsValid() bool {
+	switch theSettings.Transport {
+	case "", transportWebhook, transportPolling:
+		return true
+	}
+	return false
+}
+
+// String masks secrets so settings are safe to log
+func (theSettings BotSettings) String() string {
+	type plainSettings BotSettings
+	masked := plainSettings(theSettings)
+	masked.ID = model.Mask
+	return fmt.Sprintf("%+v", masked)
+}
+
+// secrets returns every secret value from settings
+func (theSettings BotSettings) secrets() []string {
+	encryption := theSettings.Encryption
+	secrets := []string{
+		theSettings.ID,
+		theSettings.StorageSettings.APIKey,
+		theSettings.StorageSettings.Password,
+		encryption.Key,
+		theSettings.Webhook.SecretToken,
+	}
+	return append(secrets, encryption.PreviousKeys...)
+}
+
+func (theSettings BotSettings) storageBackend() string {
+	if theSettings.Storage == "" {
+		return storageFirebase
+	}
+	return theSettings.Storage
+}
+
+func (theSettings BotSettings) storageSettingsAreValid() bool {
+	switch theSettings.Storage {
+	case "", storageFirebase:
+		fbSettings := &theSettings.StorageSettings
+		return fbSettings.APIKey != "" &&
+			fbSettings.BaseURL != "" &&
+			fbSettings.Login != "" &&
+			fbSettings.Password != ""
+	case storageBolt:
+		return theSettings.BoltSettings.Path != ""
+	}
+	return false
 }
 
 // FirebaseSettings struct is to store/retrieve settings
@@ -108,3 +369,202 @@ type FirebaseSettings struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
+
+// WebhookSettings struct is to set up webhook listener
+// Port and Path are where updates are accepted (8080 and "/" by default),
+// SecretToken is the secret_token passed to setWebhook, requests without it are rejected
+type WebhookSettings struct {
+	Port        int    `json:"port,omitempty"`
+	Path        string `json:"path,omitempty"`
+	SecretToken string `json:"secretToken,omitempty"`
+}
+
+const defaultWebhookPort = 8080
+
+// secretTokenPattern is what Telegram accepts as secret_token
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// String masks secret token so settings are safe to log
+func (hookSettings WebhookSettings) String() string {
+	return fmt.Sprintf("{Port:%v Path:%v SecretToken:%v}",
+		hookSettings.Port, hookSettings.Path, model.Mask)
+}
+
+func (hookSettings WebhookSettings) areValid() bool {
+	return hookSettings.Port >= 0 && hookSettings.Port <= 65535 &&
+		(hookSettings.Path == "" || strings.HasPrefix(hookSettings.Path, "/")) &&
+		(hookSettings.SecretToken == "" || secretTokenPattern.MatchString(hookSettings.SecretToken))
+}
+
+func (hookSettings WebhookSettings) port() int {
+	if hookSettings.Port == 0 {
+		return defaultWebhookPort
+	}
+	return hookSettings.Port
+}
+
+func (hookSettings WebhookSettings) path() string {
+	if hookSettings.Path == "" {
+		return "/"
+	}
+	return hookSettings.Path
+}
+
+// LogSettings struct is to tune logging
+// Level is the least level written: "debug", "info" (default), "warn" or "error",
+// Format is "logfmt" (default) or "json",
+// Path is a log file, "-" writes to stdout, -logpath flag is used if empty
+type LogSettings struct {
+	Level  string `json:"level,omitempty"`
+	Format string `json:"format,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+const logToStdout = "-"
+
+func (logSettings LogSettings) areValid() bool {
+	_, err := parseLogLevel(logSettings.Level)
+	switch logSettings.Format {
+	case "", formatLogfmt, formatJSON:
+		return err == nil
+	}
+	return false
+}
+
+func (logSettings LogSettings) format() string {
+	if logSettings.Format == "" {
+		return formatLogfmt
+	}
+	return logSettings.Format
+}
+
+func (logSettings LogSettings) path(defaultPath string) string {
+	if logSettings.Path == "" {
+		return defaultPath
+	}
+	return logSettings.Path
+}
+
+// MonitoringSettings struct is to expose metrics and health probes
+// Port serves /metrics, /healthz and /readyz (9090 by default)
+type MonitoringSettings struct {
+	Port int `json:"port,omitempty"`
+}
+
+const defaultMonitoringPort = 9090
+
+func (monSettings MonitoringSettings) areValid() bool {
+	return monSettings.Port >= 0 && monSettings.Port <= 65535
+}
+
+func (monSettings MonitoringSettings) port() int {
+	if monSettings.Port == 0 {
+		return defaultMonitoringPort
+	}
+	return monSettings.Port
+}
+
+// PollingSettings struct is to tune getUpdates long polling
+// OffsetPath is a file keeping the next update to fetch across restarts,
+// Timeout is how long Telegram holds a request if there are no updates (e.g. "30s")
+type PollingSettings struct {
+	OffsetPath string `json:"offsetPath,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+}
+
+const (
+	defaultOffsetPath     = "ercInfoBot.offset"
+	defaultPollingTimeout = 30 * time.Second
+)
+
+func (pollSettings PollingSettings) offsetPath() string {
+	if pollSettings.OffsetPath == "" {
+		return defaultOffsetPath
+	}
+	return pollSettings.OffsetPath
+}
+
+func (pollSettings PollingSettings) timeout() time.Duration {
+	timeout, err := time.ParseDuration(pollSettings.Timeout)
+	if err != nil || timeout < time.Second {
+		return defaultPollingTimeout
+	}
+	return timeout
+}
+
+// NotifierSettings struct is to tune balance checks
+// Concurrency is a number of users checked simultaneously,
+// CheckTimeout limits every ERC call (e.g. "30s"),
+// MaxBackoff limits a pause after failed checks (e.g. "24h"),
+// AuthFailuresLimit is a number of failed logins in a row after which checks are paused until /reg.
+// erclib doesn't tell rejected credentials from unavailable ERC, so the limit should outlast ERC outages:
+// e.g. with hourly checks and default MaxBackoff checks are paused after more than two days of failed logins.
+type NotifierSettings struct {
+	Concurrency       int    `json:"concurrency,omitempty"`
+	CheckTimeout      string `json:"checkTimeout,omitempty"`
+	MaxBackoff        string `json:"maxBackoff,omitempty"`
+	AuthFailuresLimit int    `json:"authFailuresLimit,omitempty"`
+}
+
+const (
+	defaultConcurrency       = 4
+	defaultCheckTimeout      = 30 * time.Second
+	defaultMaxBackoff        = 24 * time.Hour
+	defaultAuthFailuresLimit = 7
+)
+
+func (ntfSettings NotifierSettings) maxBackoff() time.Duration {
+	maxBackoff, err := time.ParseDuration(ntfSettings.MaxBackoff)
+	if err != nil || maxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return maxBackoff
+}
+
+func (ntfSettings NotifierSettings) authFailuresLimit() int {
+	if ntfSettings.AuthFailuresLimit <= 0 {
+		return defaultAuthFailuresLimit
+	}
+	return ntfSettings.AuthFailuresLimit
+}
+
+func (ntfSettings NotifierSettings) concurrency() int {
+	if ntfSettings.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return ntfSettings.Concurrency
+}
+
+func (ntfSettings NotifierSettings) checkTimeout() time.Duration {
+	timeout, err := time.ParseDuration(ntfSettings.CheckTimeout)
+	if err != nil || timeout <= 0 {
+		return defaultCheckTimeout
+	}
+	return timeout
+}
+
+// String masks secrets so settings are safe to log
+func (fbSettings FirebaseSettings) String() string {
+	return fmt.Sprintf("{BaseURL:%v Login:%v APIKey:%v Password:%v}",
+		fbSettings.BaseURL, fbSettings.Login, model.Mask, model.Mask)
+}
+
+// BoltSettings struct is to configure local file storage
+type BoltSettings struct {
+	Path string `json:"path"`
+}
+
+// EncryptionSettings struct is to configure credentials encryption
+// Key is base64-encoded 256-bit key, KeyFile is a file to read it from if Key is empty.
+// PreviousKeys are still accepted for decryption after rotation.
+type EncryptionSettings struct {
+	Key          string   `json:"key,omitempty"`
+	KeyFile      string   `json:"keyFile,omitempty"`
+	PreviousKeys []string `json:"previousKeys,omitempty"`
+}
+
+// String masks keys so settings are safe to log
+func (encSettings EncryptionSettings) String() string {
+	return fmt.Sprintf("{KeyFile:%v Key:%v PreviousKeys:%v}",
+		encSettings.KeyFile, model.Mask, len(encSettings.PreviousKeys))
+}
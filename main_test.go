@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
 )
 
 func TestParseReg_IgnoreArgs_IfMore(t *testing.T) {
@@ -15,6 +20,18 @@ func TestParseReg_ExactArgs(t *testing.T) {
 	assureCorrectRegCmd(command, err, t)
 }
 
+func TestParseReg_NoArgs_IfLess(t *testing.T) {
+	for _, text := range []string{"/reg", "/reg a_aaa@a.com"} {
+		command, err := ParseCommand(text)
+		if err != nil {
+			t.Error("Error should not have happened")
+		}
+		if command.Command != "/reg" || len(command.Args) != 0 {
+			t.Error("expected /reg without args, but got ", command)
+		}
+	}
+}
+
 func TestParseNotify(t *testing.T) {
 	command, _ := ParseCommand("/notify on")
 	if command.Command != "/notify" {
@@ -22,6 +39,16 @@ func TestParseNotify(t *testing.T) {
 	}
 }
 
+func TestParseUnsubscribe(t *testing.T) {
+	command, err := ParseCommand("/unsubscribe account1")
+	if err != nil {
+		t.Error("Error while parse command")
+	}
+	if command.Command != "/unsubscribe" || command.Args[0] != "account1" {
+		t.Error("expected /unsubscribe account1, but got ", command)
+	}
+}
+
 func TestParseInfoCommands(t *testing.T) {
 	var doTest = func(t *testing.T, cmd string) {
 		command, err := ParseCommand(fmt.Sprintf("%v account1", "/receipt"))
@@ -60,3 +87,144 @@ func assureCorrectRegCmd(command Command, err error, t *testing.T) {
 		t.Error("expected qwe123QWE!@#, but got ", command.Args[1])
 	}
 }
+
+func TestParseQuotedAndEscapedArgs(t *testing.T) {
+	cases := map[string][]string{
+		`/reg login "pass word"`:       {"login", "pass word"},
+		`/reg login 'pa"ss \word'`:     {"login", `pa"ss \word`},
+		`/reg login pass\ word`:        {"login", "pass word"},
+		`/reg "" ""`:                   {"", ""},
+		"/reg\tlogin  \n password":     {"login", "password"},
+		`/reg@ErcInfoBot login "a\"b"`: {"login", `a"b`},
+	}
+	for text, expected := range cases {
+		command, err := ParseCommand(text)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", text, err)
+			continue
+		}
+		if command.Command != "/reg" || strings.Join(command.Args, "|") != strings.Join(expected, "|") {
+			t.Errorf("%v: expected /reg %q, but got %v %q", text, expected, command.Command, command.Args)
+		}
+	}
+}
+
+func TestParseRejectsMalformedText(t *testing.T) {
+	for _, text := range []string{"", "   ", `/reg "login`, `/reg login 'pass`, `/reg login pass\`, "/unknown", "hello"} {
+		if _, err := ParseCommand(text); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
+
+func TestParseReportsUsage(t *testing.T) {
+	_, err := ParseCommand("/history 1234567 -1")
+
+	usageErr, ok := err.(usageError)
+	if !ok {
+		t.Fatal("Expected usage error, but got ", err)
+	}
+	if !strings.Contains(usageErr.Error(), "/history [лицевой счет] [месяцев]") {
+		t.Error("Expected usage in error, but got ", usageErr.Error())
+	}
+}
+
+func TestHelpListsVisibleCommands(t *testing.T) {
+	text := help(makeMsgUpdate("/help"), newTranslator(langRU)).Text
+
+	for _, spec := range commands {
+		listed := strings.Contains(text, spec.name+" ")
+		if listed != (spec.help != "") {
+			t.Errorf("%v: listed in help is %v", spec.name, listed)
+		}
+	}
+	if strings.Contains(text, "действие") {
+		t.Error("Internal arguments must not be shown: ", text)
+	}
+}
+
+func TestUsageErrorIsReplied(t *testing.T) {
+	h := createHandler(createFakeStorage(), newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/history 1234567 0"))
+
+	msg, ok := reply.(telegram.ReplyMessage)
+	if !ok || !strings.Contains(msg.Text, "Использование: /history") {
+		t.Error("Expected usage, but got ", reply)
+	}
+}
+
+func TestParseIgnoresExtraArgsOfEveryCommand(t *testing.T) {
+	for _, spec := range commands {
+		for _, arg := range []string{"1", "any", "off", "total_above"} {
+			text := spec.name + strings.Repeat(" "+arg, len(spec.args)+2)
+			cmd, _ := ParseCommand(text)
+			if len(cmd.Args) > len(spec.args) {
+				t.Errorf("%q: too many args %q", text, cmd.Args)
+			}
+		}
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range []string{`/reg login "pass word"`, `a\ b 'c d' "e\"f"`, `"`, `\`, "\t\n"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		tokens, err := tokenize(text)
+		if err != nil {
+			return
+		}
+		// quoting every token back must give the same tokens
+		quoted := make([]string, len(tokens))
+		for i, token := range tokens {
+			quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(token) + `"`
+		}
+		again, err := tokenize(strings.Join(quoted, " "))
+		if err != nil {
+			t.Fatalf("%q: requoted tokens aren't parsed: %v", text, err)
+		}
+		if fmt.Sprintf("%q", again) != fmt.Sprintf("%q", tokens) {
+			t.Fatalf("%q: expected %q, but got %q", text, tokens, again)
+		}
+	})
+}
+
+func FuzzParseCommand(f *testing.F) {
+	for _, seed := range []string{"/reg login password", "/history 3", "/history 1 -1", "/logout confirm", `/get "1`,
+		"/alert total_above 5000 x", "/alert debt_increase 10 foo", "/history 3 1 2", "/reg a b c"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		command, err := ParseCommand(text)
+		if err != nil {
+			return
+		}
+		spec, ok := findCommand(command.Command)
+		if !ok {
+			t.Fatalf("%q: unknown command %v parsed", text, command.Command)
+		}
+		if len(command.Args) > len(spec.args) {
+			t.Fatalf("%q: too many args %q", text, command.Args)
+		}
+	})
+}
+
+func FuzzRedactCommandText(f *testing.F) {
+	f.Add(secretPassword)
+	f.Add(`"` + secretPassword)
+	f.Fuzz(func(t *testing.T, password string) {
+		redactCommandText(password)
+		redactCommandText("/reg login " + password)
+
+		if password == "" || strings.ContainsAny(password, " \t\n\v\f\r\u0085\u00a0\"'\\") ||
+			strings.Contains("/reg login "+model.Mask, password) {
+			return
+		}
+		if redacted := redactCommandText("/reg login " + password); strings.Contains(redacted, password) {
+			t.Fatalf("%q leaked in %q", password, redacted)
+		}
+	})
+}
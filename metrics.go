@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsRegistry exposes metrics in Prometheus text format
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []metricsCollector
+}
+
+type metricsCollector interface {
+	write(w io.Writer)
+}
+
+func (r *metricsRegistry) register(c metricsCollector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *metricsRegistry) counter(name string, help string, labels ...string) *counterVec {
+	c := &counterVec{metricInfo: metricInfo{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+func (r *metricsRegistry) histogram(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{
+		metricInfo: metricInfo{name: name, help: help, labels: labels},
+		buckets:    buckets,
+		series:     make(map[string]*histogramSeries),
+	}
+	r.register(h)
+	return h
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.collectors {
+		c.write(w)
+	}
+}
+
+type metricInfo struct {
+	name   string
+	help   string
+	labels []string
+}
+
+func (m metricInfo) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, metricType)
+}
+
+// key joins label values into a map key, values must match labels
+func (m metricInfo) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("%v expects labels %v, got %v", m.name, m.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// formatLabels renders {name="value",...} for a key, extra is appended as is (e.g. le="0.5")
+func (m metricInfo) formatLabels(key string, extra string) string {
+	var pairs []string
+	if len(m.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, m.labels[i]+"="+strconv.Quote(value))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// counterVec is a counter per combination of label values
+type counterVec struct {
+	metricInfo
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counterVec) add(delta float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += delta
+}
+
+func (c *counterVec) value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.writeHeader(w, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, c.formatLabels(key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram per combination of label values
+type histogramVec struct {
+	metricInfo
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// counts are per bucket, not cumulative
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += value
+}
+
+// observeSince records seconds elapsed since start
+func (h *histogramVec) observeSince(start time.Time, labelValues ...string) {
+	h.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *histogramVec) count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.writeHeader(w, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := "le=" + strconv.Quote(formatFloat(bound))
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.formatLabels(key, le), cumulative)
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.formatLabels(key, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, h.formatLabels(key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, h.formatLabels(key, ""), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minya/ercInfoBot/model"
+)
+
+func TestCounterExposition(t *testing.T) {
+	registry := &metricsRegistry{}
+	counter := registry.counter("test_total", "Test counter.", "command", "outcome")
+	counter.inc("/get", "ok")
+	counter.add(2, "/get", "ok")
+	counter.inc("/reg", `say "hi"`)
+
+	body := scrape(t, registry)
+
+	expected := "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{command=\"/get\",outcome=\"ok\"} 3\n" +
+		"test_total{command=\"/reg\",outcome=\"say \\\"hi\\\"\"} 1\n"
+	if body != expected {
+		t.Errorf("Expected:\n%v\nbut got:\n%v", expected, body)
+	}
+}
+
+func TestHistogramExposition(t *testing.T) {
+	registry := &metricsRegistry{}
+	histogram := registry.histogram("test_seconds", "Test histogram.", []float64{0.1, 1})
+	histogram.observe(0.05)
+	histogram.observe(0.1)
+	histogram.observe(0.5)
+	histogram.observe(3)
+
+	body := scrape(t, registry)
+
+	for _, line := range []string{
+		"# TYPE test_seconds histogram",
+		`test_seconds_bucket{le="0.1"} 2`,
+		`test_seconds_bucket{le="1"} 3`,
+		`test_seconds_bucket{le="+Inf"} 4`,
+		"test_seconds_sum 3.65",
+		"test_seconds_count 4",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %v in:\n%v", line, body)
+		}
+	}
+}
+
+func TestWrongLabelsPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic")
+		}
+	}()
+	(&metricsRegistry{}).counter("test_total", "Test counter.", "method").inc()
+}
+
+func TestInstrumentedStorageReportsFailures(t *testing.T) {
+	ready := newReadiness(checkStorage)
+	base := &failingStorage{err: errors.New("unavailable")}
+	storage := instrumentedStorage{storage: base, backend: "test", health: ready}
+	errorsBefore := storageErrors.value("test", "get_users")
+	callsBefore := storageDuration.count("test", "get_users")
+
+	storage.GetUsers(context.Background())
+
+	if storageErrors.value("test", "get_users") != errorsBefore+1 {
+		t.Error("Expected storage error to be counted")
+	}
+	if storageDuration.count("test", "get_users") != callsBefore+1 {
+		t.Error("Expected storage latency to be observed")
+	}
+	if ready.checks[checkStorage] == nil {
+		t.Error("Expected storage to be not ready")
+	}
+
+	base.err = nil
+	storage.GetUsers(context.Background())
+	if ready.checks[checkStorage] != nil {
+		t.Error("Expected storage to be ready again, but got ", ready.checks[checkStorage])
+	}
+}
+
+func TestInstrumentedERCClientCountsErrors(t *testing.T) {
+	client := createFakeERCClient(1)
+	client.accountsErr = errors.New("Authentication error")
+	before := ercErrors.value("accounts")
+
+	instrumentedERCClient{client: client}.GetAccounts()
+
+	if ercErrors.value("accounts") != before+1 {
+		t.Error("Expected ERC error to be counted")
+	}
+}
+
+func TestCommandsAreCounted(t *testing.T) {
+	h := createHandler(createFakeStorage(), newFakeHistory(), func(string, string) ercclient {
+		return createFakeERCClient(1)
+	}, &fakeBot{})
+	okBefore := commandsTotal.value("/get", outcomeOK)
+	invalidBefore := commandsTotal.value("/history", outcomeInvalid)
+
+	h.handle(context.Background(), makeMsgUpdate("/get"))
+	h.handle(context.Background(), makeMsgUpdate("/history 1 0"))
+
+	if commandsTotal.value("/get", outcomeOK) != okBefore+1 {
+		t.Error("Expected /get to be counted")
+	}
+	if commandsTotal.value("/history", outcomeInvalid) != invalidBefore+1 {
+		t.Error("Expected invalid /history to be counted")
+	}
+}
+
+func scrape(t *testing.T, registry *metricsRegistry) string {
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Error("Unexpected content type: ", recorder.Header().Get("Content-Type"))
+	}
+	return recorder.Body.String()
+}
+
+// failingStorage fails every call with err
+type failingStorage struct {
+	err error
+}
+
+func (s *failingStorage) GetUserInfo(ctx context.Context, userID int) (model.UserInfo, error) {
+	return model.UserInfo{}, s.err
+}
+
+func (s *failingStorage) SaveUser(ctx context.Context, userID int, userInfo model.UserInfo) error {
+	return s.err
+}
+
+func (s *failingStorage) GetUsers(ctx context.Context) (map[int]model.UserInfo, error) {
+	return nil, s.err
+}
+
+func (s *failingStorage) DeleteUser(ctx context.Context, userID int) error {
+	return s.err
+}
+
+func (s *failingStorage) AppendBalance(
+	ctx context.Context, userID int, account string, entry model.BalanceHistoryEntry) (bool, error) {
+	return false, s.err
+}
+
+func (s *failingStorage) GetBalanceHistory(
+	ctx context.Context, userID int, account string) ([]model.BalanceHistoryEntry, error) {
+	return nil, s.err
+}
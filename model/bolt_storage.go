@@ -0,0 +1,184 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	accountsBucket = []byte("accounts")
+	historyBucket  = []byte("history")
+)
+
+// BoltStorage keeps users in a local single-file bolt database
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage opens (or creates) the database file at path
+func NewBoltStorage(path string) (BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return BoltStorage{}, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{accountsBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return BoltStorage{}, err
+	}
+	return BoltStorage{db: db}, nil
+}
+
+// Close releases the database file
+func (s BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// GetUserInfo returns stored user or empty UserInfo if there is no such user
+func (s BoltStorage) GetUserInfo(ctx context.Context, userID int) (UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return UserInfo{}, err
+	}
+	var result UserInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(accountsBucket).Get(userKey(userID))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &result)
+	})
+	return result, err
+}
+
+// SaveUser overwrites user record
+func (s BoltStorage) SaveUser(ctx context.Context, userID int, userInfo UserInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(userInfo)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).Put(userKey(userID), data)
+	})
+}
+
+// DeleteUser removes user record and balance history, it's not an error if there is no such user
+func (s BoltStorage) DeleteUser(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(accountsBucket).Delete(userKey(userID)); err != nil {
+			return err
+		}
+		history := tx.Bucket(historyBucket)
+		prefix := historyKey(userID, "")
+		var keys [][]byte
+		cursor := history.Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetUsers returns all stored users by their ids
+func (s BoltStorage) GetUsers(ctx context.Context) (map[int]UserInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result := make(map[int]UserInfo)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).ForEach(func(k, v []byte) error {
+			userID, err := strconv.Atoi(string(k))
+			if err != nil {
+				return err
+			}
+			var userInfo UserInfo
+			if err = json.Unmarshal(v, &userInfo); err != nil {
+				return err
+			}
+			result[userID] = userInfo
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AppendBalance adds entry to account's history unless it repeats the latest one
+func (s BoltStorage) AppendBalance(ctx context.Context, userID int, account string, entry BalanceHistoryEntry) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	added := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket)
+		history, err := readHistory(bucket, historyKey(userID, account))
+		if err != nil {
+			return err
+		}
+		if history, added = appendIfChanged(history, entry); !added {
+			return nil
+		}
+		data, err := json.Marshal(history)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(userID, account), data)
+	})
+	return added, err
+}
+
+// GetBalanceHistory returns account's history, oldest first
+func (s BoltStorage) GetBalanceHistory(ctx context.Context, userID int, account string) ([]BalanceHistoryEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var history []BalanceHistoryEntry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		history, err = readHistory(tx.Bucket(historyBucket), historyKey(userID, account))
+		return err
+	})
+	return history, err
+}
+
+func readHistory(bucket *bolt.Bucket, key []byte) ([]BalanceHistoryEntry, error) {
+	var history []BalanceHistoryEntry
+	data := bucket.Get(key)
+	if data == nil {
+		return history, nil
+	}
+	err := json.Unmarshal(data, &history)
+	return history, err
+}
+
+func historyKey(userID int, account string) []byte {
+	return []byte(strconv.Itoa(userID) + "/" + account)
+}
+
+func userKey(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
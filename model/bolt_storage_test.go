@@ -0,0 +1,162 @@
+package model
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltGetUserInfoReturnsEmptyUserIfNotFound(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	userInfo, err := storage.GetUserInfo(context.Background(), 100500)
+	if err != nil {
+		t.Error("Error should not have happened: ", err)
+	}
+	if userInfo.Login != "" || userInfo.Subscriptions != nil {
+		t.Error("Expected empty user, but got ", userInfo)
+	}
+}
+
+func TestBoltSaveUserThenGetUserInfo(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	saved := makeTestUser("login@gmail.com")
+	if err := storage.SaveUser(context.Background(), 100500, saved); err != nil {
+		t.Fatal("Error while saving user: ", err)
+	}
+
+	got, err := storage.GetUserInfo(context.Background(), 100500)
+	if err != nil {
+		t.Fatal("Error while reading user: ", err)
+	}
+	if got.Login != saved.Login || got.Password != saved.Password {
+		t.Error("Credentials mismatch: ", got)
+	}
+	sub := got.Subscriptions["account_0"]
+	if sub.ChatID != 404040 || sub.LastSeen == nil || sub.LastSeen.Rows[0].Amount != 1 {
+		t.Error("Subscription mismatch: ", got.Subscriptions)
+	}
+}
+
+func TestBoltSaveUserOverwritesExisting(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	storage.SaveUser(context.Background(), 1, makeTestUser("old@gmail.com"))
+	storage.SaveUser(context.Background(), 1, UserInfo{Login: "new@gmail.com"})
+
+	got, _ := storage.GetUserInfo(context.Background(), 1)
+	if got.Login != "new@gmail.com" {
+		t.Error("expected new@gmail.com, but got ", got.Login)
+	}
+	if got.Subscriptions != nil {
+		t.Error("Subscriptions should have been overwritten")
+	}
+}
+
+func TestBoltGetUsersReturnsAllUsers(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	storage.SaveUser(context.Background(), 1, makeTestUser("first@gmail.com"))
+	storage.SaveUser(context.Background(), 2, makeTestUser("second@gmail.com"))
+
+	users, err := storage.GetUsers(context.Background())
+	if err != nil {
+		t.Fatal("Error while reading users: ", err)
+	}
+	if len(users) != 2 {
+		t.Fatal("Expected 2 users, but got ", len(users))
+	}
+	if users[1].Login != "first@gmail.com" || users[2].Login != "second@gmail.com" {
+		t.Error("Users mismatch: ", users)
+	}
+}
+
+func TestBoltGetUsersReturnsEmptyMapIfNoUsers(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	users, err := storage.GetUsers(context.Background())
+	if err != nil {
+		t.Error("Error should not have happened: ", err)
+	}
+	if users == nil || len(users) != 0 {
+		t.Error("Expected empty map, but got ", users)
+	}
+}
+
+func TestBoltDeleteUserRemovesUser(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	storage.SaveUser(context.Background(), 1, makeTestUser("first@gmail.com"))
+	storage.SaveUser(context.Background(), 2, makeTestUser("second@gmail.com"))
+
+	if err := storage.DeleteUser(context.Background(), 1); err != nil {
+		t.Fatal("Error while deleting user: ", err)
+	}
+
+	got, _ := storage.GetUserInfo(context.Background(), 1)
+	if got.Login != "" {
+		t.Error("User was not deleted: ", got)
+	}
+	users, _ := storage.GetUsers(context.Background())
+	if len(users) != 1 || users[2].Login != "second@gmail.com" {
+		t.Error("Only deleted user must be removed: ", users)
+	}
+}
+
+func TestBoltDeleteMissingUserIsNotAnError(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	if err := storage.DeleteUser(context.Background(), 1); err != nil {
+		t.Error("Error should not have happened: ", err)
+	}
+}
+
+func TestBoltStoragePersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	storage, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.SaveUser(context.Background(), 1, makeTestUser("login@gmail.com"))
+	storage.Close()
+
+	reopened, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, _ := reopened.GetUserInfo(context.Background(), 1)
+	if got.Login != "login@gmail.com" {
+		t.Error("expected login@gmail.com, but got ", got.Login)
+	}
+}
+
+func TestBoltRefusesDoneContext(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := storage.SaveUser(ctx, 1, makeTestUser("login@gmail.com")); err == nil {
+		t.Error("Expected error for cancelled context")
+	}
+	got, _ := storage.GetUserInfo(context.Background(), 1)
+	if got.Login != "" {
+		t.Error("User must not be written: ", got)
+	}
+}
+
+func createTestBoltStorage(t *testing.T) BoltStorage {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal("Unable to open storage: ", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func makeTestUser(login string) UserInfo {
+	return UserInfo{
+		Login:    login,
+		Password: "qwe123QWE!@#",
+		Subscriptions: map[string]SubscriptionInfo{
+			"account_0": {
+				ChatID:   404040,
+				LastSeen: &BalanceSnapshot{Month: "Январь", Rows: []BalanceRow{{Requisite: "Итого", Amount: 1}}},
+			},
+		},
+	}
+}
@@ -0,0 +1,270 @@
+package model
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	sealedPrefix = "enc:v1:"
+	keySize      = 32
+)
+
+// Keyring holds master keys: the current one seals data,
+// any of them can open data sealed before rotation
+type Keyring struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+// NewKeyring creates keyring from base64-encoded 256-bit keys
+func NewKeyring(currentKey string, previousKeys ...string) (Keyring, error) {
+	keyring := Keyring{keys: make(map[string][]byte)}
+	for i, encoded := range append([]string{currentKey}, previousKeys...) {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return Keyring{}, fmt.Errorf("Unable to decode key #%v: %v", i, err)
+		}
+		if len(key) != keySize {
+			return Keyring{}, fmt.Errorf("Key #%v must be %v bytes long, got %v", i, keySize, len(key))
+		}
+		id := keyID(key)
+		if i == 0 {
+			keyring.currentID = id
+		}
+		keyring.keys[id] = key
+	}
+	return keyring, nil
+}
+
+// Seal encrypts plaintext with a fresh data key wrapped by the current master key
+func (k Keyring) Seal(plaintext string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := gcmSeal(k.keys[k.currentID], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := gcmSeal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return sealedPrefix + k.currentID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrappedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts value produced by Seal
+func (k Keyring) Open(sealed string) (string, error) {
+	id, wrappedKey, ciphertext, err := splitSealed(sealed)
+	if err != nil {
+		return "", err
+	}
+	masterKey, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("Unknown encryption key %v", id)
+	}
+	dataKey, err := gcmOpen(masterKey, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcmOpen(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (k Keyring) isSealedWithCurrentKey(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix+k.currentID+":")
+}
+
+func isSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+func splitSealed(sealed string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if !isSealed(sealed) || len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("Malformed sealed value")
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return parts[0], wrappedKey, ciphertext, nil
+}
+
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func gcmSeal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key []byte, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("Sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedStorage seals users' passwords before passing them to underlying storage
+// and opens them on read. Plaintext passwords found on read are sealed and written back.
+type EncryptedStorage struct {
+	storage UserStorage
+	keys    Keyring
+	logger  Logger
+}
+
+// NewEncryptedStorage wraps storage with password encryption, logger may be nil
+func NewEncryptedStorage(storage UserStorage, keys Keyring, logger Logger) EncryptedStorage {
+	return EncryptedStorage{storage: storage, keys: keys, logger: orNop(logger)}
+}
+
+// GetUserInfo reads user and decrypts its password.
+// On error no user is returned, so a sealed password can't be mistaken for a plaintext one.
+func (s EncryptedStorage) GetUserInfo(ctx context.Context, userID int) (UserInfo, error) {
+	userInfo, err := s.storage.GetUserInfo(ctx, userID)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	opened, err := s.open(ctx, userID, userInfo)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	return opened, nil
+}
+
+// SaveUser encrypts password and writes user
+func (s EncryptedStorage) SaveUser(ctx context.Context, userID int, userInfo UserInfo) error {
+	sealed, err := s.seal(userInfo)
+	if err != nil {
+		return err
+	}
+	return s.storage.SaveUser(ctx, userID, sealed)
+}
+
+// GetUsers reads all users decrypting their passwords.
+// Users whose password can't be decrypted are skipped.
+func (s EncryptedStorage) GetUsers(ctx context.Context) (map[int]UserInfo, error) {
+	users, err := s.storage.GetUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]UserInfo, len(users))
+	for userID, userInfo := range users {
+		opened, err := s.open(ctx, userID, userInfo)
+		if err != nil {
+			s.logger.Error(ctx, fmt.Sprintf("Unable to decrypt credentials: %v", err), "user", userID)
+			continue
+		}
+		result[userID] = opened
+	}
+	return result, nil
+}
+
+// DeleteUser removes user from underlying storage
+func (s EncryptedStorage) DeleteUser(ctx context.Context, userID int) error {
+	return s.storage.DeleteUser(ctx, userID)
+}
+
+// ReEncryptAll seals every stored password with the current key.
+// It returns the number of rewritten users.
+func (s EncryptedStorage) ReEncryptAll(ctx context.Context) (int, error) {
+	users, err := s.storage.GetUsers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for userID, userInfo := range users {
+		if userInfo.Password == "" || s.keys.isSealedWithCurrentKey(userInfo.Password) {
+			continue
+		}
+		opened, err := s.openPassword(userInfo.Password)
+		if err != nil {
+			return count, fmt.Errorf("User %v: %v", userID, err)
+		}
+		userInfo.Password = opened
+		if err = s.SaveUser(ctx, userID, userInfo); err != nil {
+			return count, fmt.Errorf("User %v: %v", userID, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s EncryptedStorage) open(ctx context.Context, userID int, userInfo UserInfo) (UserInfo, error) {
+	if userInfo.Password != "" && !isSealed(userInfo.Password) {
+		s.logger.Info(ctx, "Migrating plaintext credentials", "user", userID)
+		if err := s.SaveUser(ctx, userID, userInfo); err != nil {
+			s.logger.Error(ctx, fmt.Sprintf("Unable to migrate credentials: %v", err), "user", userID)
+		}
+		return userInfo, nil
+	}
+	password, err := s.openPassword(userInfo.Password)
+	if err != nil {
+		return userInfo, err
+	}
+	userInfo.Password = password
+	return userInfo, nil
+}
+
+func (s EncryptedStorage) openPassword(password string) (string, error) {
+	if password == "" || !isSealed(password) {
+		return password, nil
+	}
+	return s.keys.Open(password)
+}
+
+func (s EncryptedStorage) seal(userInfo UserInfo) (UserInfo, error) {
+	if userInfo.Password == "" {
+		return userInfo, nil
+	}
+	if isSealed(userInfo.Password) {
+		return userInfo, fmt.Errorf("Password is already sealed")
+	}
+	sealed, err := s.keys.Seal(userInfo.Password)
+	if err != nil {
+		return userInfo, err
+	}
+	userInfo.Password = sealed
+	return userInfo, nil
+}
@@ -0,0 +1,199 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+const testPassword = "qwe123QWE!@#"
+
+func TestSealThenOpenReturnsPlaintext(t *testing.T) {
+	keys := createTestKeyring(t, newTestKey())
+	sealed, err := keys.Seal(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, testPassword) || !isSealed(sealed) {
+		t.Error("Value is not sealed: ", sealed)
+	}
+	opened, err := keys.Open(sealed)
+	if err != nil || opened != testPassword {
+		t.Error("Expected original password, but got ", opened, err)
+	}
+}
+
+func TestOpenFailsWithUnknownKey(t *testing.T) {
+	sealed, _ := createTestKeyring(t, newTestKey()).Seal(testPassword)
+	if _, err := createTestKeyring(t, newTestKey()).Open(sealed); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
+
+func TestNewKeyringRejectsShortKey(t *testing.T) {
+	if _, err := NewKeyring(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("Expected error for short key")
+	}
+}
+
+func TestEncryptedSaveUserStoresNoPlaintext(t *testing.T) {
+	raw := newMemoryStorage()
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+
+	storage.SaveUser(context.Background(), 1, UserInfo{Login: "login@gmail.com", Password: testPassword})
+
+	if stored := raw.users[1].Password; !isSealed(stored) {
+		t.Error("Password stored in plain text: ", stored)
+	}
+	got, err := storage.GetUserInfo(context.Background(), 1)
+	if err != nil || got.Password != testPassword {
+		t.Error("Expected decrypted password, but got ", got.Password, err)
+	}
+}
+
+func TestEncryptedGetUserInfoMigratesPlaintextRecord(t *testing.T) {
+	raw := newMemoryStorage()
+	raw.users[1] = UserInfo{Login: "login@gmail.com", Password: testPassword}
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+
+	got, err := storage.GetUserInfo(context.Background(), 1)
+	if err != nil || got.Password != testPassword {
+		t.Error("Expected plaintext password, but got ", got.Password, err)
+	}
+	if !isSealed(raw.users[1].Password) {
+		t.Error("Record was not migrated")
+	}
+}
+
+func TestEncryptedGetUserInfoHidesUndecryptableRecord(t *testing.T) {
+	raw := newMemoryStorage()
+	oldKey := newTestKey()
+	NewEncryptedStorage(raw, createTestKeyring(t, oldKey), nil).SaveUser(
+		context.Background(), 1, UserInfo{Login: "login@gmail.com", Password: testPassword})
+	sealed := raw.users[1].Password
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+
+	got, err := storage.GetUserInfo(context.Background(), 1)
+	if err == nil || got.Login != "" || got.Password != "" {
+		t.Error("Expected error and no user, but got ", got, err)
+	}
+	if err = storage.SaveUser(context.Background(), 1, UserInfo{Login: "login@gmail.com", Password: sealed}); err == nil {
+		t.Error("Sealed password must not be sealed again")
+	}
+
+	restored := NewEncryptedStorage(raw, createTestKeyring(t, oldKey), nil)
+	if got, err = restored.GetUserInfo(context.Background(), 1); err != nil || got.Password != testPassword {
+		t.Error("Expected credentials to be readable with the old key, but got ", got.Password, err)
+	}
+}
+
+func TestEncryptedGetUsersDecryptsAndMigrates(t *testing.T) {
+	raw := newMemoryStorage()
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+	storage.SaveUser(context.Background(), 1, UserInfo{Login: "first@gmail.com", Password: "first"})
+	raw.users[2] = UserInfo{Login: "second@gmail.com", Password: "second"}
+
+	users, err := storage.GetUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users[1].Password != "first" || users[2].Password != "second" {
+		t.Error("Passwords mismatch: ", users)
+	}
+	if !isSealed(raw.users[2].Password) {
+		t.Error("Record was not migrated")
+	}
+}
+
+func TestEncryptedEmptyPasswordStaysEmpty(t *testing.T) {
+	raw := newMemoryStorage()
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+	storage.SaveUser(context.Background(), 1, UserInfo{})
+	if raw.users[1].Password != "" {
+		t.Error("Expected empty password, but got ", raw.users[1].Password)
+	}
+}
+
+func TestEncryptedDeleteUserRemovesRecord(t *testing.T) {
+	raw := newMemoryStorage()
+	storage := NewEncryptedStorage(raw, createTestKeyring(t, newTestKey()), nil)
+	storage.SaveUser(context.Background(), 1, UserInfo{Password: testPassword})
+
+	storage.DeleteUser(context.Background(), 1)
+
+	if _, ok := raw.users[1]; ok {
+		t.Error("User was not deleted")
+	}
+}
+
+func TestReEncryptAllRotatesKey(t *testing.T) {
+	oldKey, newKey := newTestKey(), newTestKey()
+	raw := newMemoryStorage()
+	NewEncryptedStorage(raw, createTestKeyring(t, oldKey), nil).SaveUser(context.Background(), 1, UserInfo{Password: testPassword})
+	raw.users[2] = UserInfo{Password: "plain"}
+
+	rotated := NewEncryptedStorage(raw, createTestKeyring(t, newKey, oldKey), nil)
+	count, err := rotated.ReEncryptAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Error("Expected 2 re-encrypted users, but got ", count)
+	}
+
+	onlyNewKey := NewEncryptedStorage(raw, createTestKeyring(t, newKey), nil)
+	got, err := onlyNewKey.GetUserInfo(context.Background(), 1)
+	if err != nil || got.Password != testPassword {
+		t.Error("Expected password readable with new key, but got ", got.Password, err)
+	}
+	got, _ = onlyNewKey.GetUserInfo(context.Background(), 2)
+	if got.Password != "plain" {
+		t.Error("Expected migrated password, but got ", got.Password)
+	}
+}
+
+func newTestKey() string {
+	key := make([]byte, keySize)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func createTestKeyring(t *testing.T, current string, previous ...string) Keyring {
+	keys, err := NewKeyring(current, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+type memoryStorage struct {
+	users map[int]UserInfo
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{users: make(map[int]UserInfo)}
+}
+
+func (s *memoryStorage) GetUserInfo(ctx context.Context, userID int) (UserInfo, error) {
+	return s.users[userID], nil
+}
+
+func (s *memoryStorage) SaveUser(ctx context.Context, userID int, userInfo UserInfo) error {
+	s.users[userID] = userInfo
+	return nil
+}
+
+func (s *memoryStorage) DeleteUser(ctx context.Context, userID int) error {
+	delete(s.users, userID)
+	return nil
+}
+
+func (s *memoryStorage) GetUsers(ctx context.Context) (map[int]UserInfo, error) {
+	result := make(map[int]UserInfo, len(s.users))
+	for userID, userInfo := range s.users {
+		result[userID] = userInfo
+	}
+	return result, nil
+}
@@ -1,11 +1,11 @@
 package model
 
 import (
-	"log"
+	"context"
+	"sort"
 	"strconv"
 
 	"github.com/melvinmt/firebase"
-	"github.com/minya/googleapis"
 )
 
 type FirebaseStorage struct {
@@ -13,36 +13,59 @@ type FirebaseStorage struct {
 	Login    string
 	Password string
 	BaseUrl  string
+
+	tokens *tokenManager
 }
 
-func NewFirebaseStorage(baseUrl string, apiKey string, login string, password string) FirebaseStorage {
+// NewFirebaseStorage creates storage signing in with login and password, logger may be nil
+func NewFirebaseStorage(baseUrl string, apiKey string, login string, password string, logger Logger) FirebaseStorage {
 	var storage FirebaseStorage
 	storage.BaseUrl = baseUrl
 	storage.ApiKey = apiKey
 	storage.Login = login
 	storage.Password = password
+	storage.tokens = newTokenManager(apiKey, login, password, logger)
 	return storage
 }
 
-func (this FirebaseStorage) GetUserInfo(userId int) (UserInfo, error) {
-	ref, err := this.getUserReference(strconv.Itoa(userId))
+func (this FirebaseStorage) GetUserInfo(ctx context.Context, userId int) (UserInfo, error) {
 	var result UserInfo
+	ref, err := this.getUserReference(ctx, strconv.Itoa(userId))
+	if err != nil {
+		return result, err
+	}
 	if err = ref.Value(&result); err != nil {
 		return result, err
 	}
 	return result, nil
 }
 
-func (this FirebaseStorage) SaveUser(userId int, userInfo UserInfo) error {
-	ref, err := this.getUserReference(strconv.Itoa(userId))
+func (this FirebaseStorage) SaveUser(ctx context.Context, userId int, userInfo UserInfo) error {
+	ref, err := this.getUserReference(ctx, strconv.Itoa(userId))
+	if err != nil {
+		return err
+	}
 	if err = ref.Write(userInfo); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (this FirebaseStorage) GetUsers() (map[int]UserInfo, error) {
-	ref, err := this.getReference("/accounts")
+func (this FirebaseStorage) DeleteUser(ctx context.Context, userId int) error {
+	for _, path := range []string{"/accounts/", "/history/"} {
+		ref, err := this.getReference(ctx, path+strconv.Itoa(userId))
+		if err != nil {
+			return err
+		}
+		if err = ref.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this FirebaseStorage) GetUsers(ctx context.Context) (map[int]UserInfo, error) {
+	ref, err := this.getReference(ctx, "/accounts")
 	if err != nil {
 		return nil, err
 	}
@@ -52,28 +75,70 @@ func (this FirebaseStorage) GetUsers() (map[int]UserInfo, error) {
 	return subsMap, nil
 }
 
-func (this FirebaseStorage) getUserReference(userId string) (*firebase.Reference, error) {
-	return this.getReference("/accounts/" + userId)
+// AppendBalance pushes entry as a new keyed child, so concurrent appends don't overwrite each other
+func (this FirebaseStorage) AppendBalance(ctx context.Context, userId int, account string, entry BalanceHistoryEntry) (bool, error) {
+	history, err := this.GetBalanceHistory(ctx, userId, account)
+	if err != nil {
+		return false, err
+	}
+	if _, added := appendIfChanged(history, entry); !added {
+		return false, nil
+	}
+	ref, err := this.getHistoryReference(ctx, userId, account)
+	if err != nil {
+		return false, err
+	}
+	if err = ref.Push(entry); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (this FirebaseStorage) getReference(path string) (*firebase.Reference, error) {
-	idToken, err := this.signIn()
-	if nil != err {
+func (this FirebaseStorage) GetBalanceHistory(ctx context.Context, userId int, account string) ([]BalanceHistoryEntry, error) {
+	ref, err := this.getHistoryReference(ctx, userId, account)
+	if err != nil {
 		return nil, err
 	}
-	base := this.BaseUrl
-	ref := firebase.NewReference(base + path).Auth(idToken)
-	return ref, nil
+	var children map[string]BalanceHistoryEntry
+	if err = ref.Value(&children); err != nil {
+		return nil, err
+	}
+	return orderPushed(children), nil
+}
+
+// orderPushed lists pushed children in the order they were pushed: push keys sort chronologically
+func orderPushed(children map[string]BalanceHistoryEntry) []BalanceHistoryEntry {
+	keys := make([]string, 0, len(children))
+	for key := range children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	history := make([]BalanceHistoryEntry, 0, len(keys))
+	for _, key := range keys {
+		history = append(history, children[key])
+	}
+	return history
 }
 
-func (this FirebaseStorage) signIn() (string, error) {
-	log.Printf("Signing in to firebase: %s", this)
-	response, err := googleapis.SignInWithEmailAndPassword(
-		this.Login, this.Password, this.ApiKey)
+func (this FirebaseStorage) getHistoryReference(ctx context.Context, userId int, account string) (*firebase.Reference, error) {
+	return this.getReference(ctx, "/history/"+strconv.Itoa(userId)+"/"+account)
+}
+
+func (this FirebaseStorage) getUserReference(ctx context.Context, userId string) (*firebase.Reference, error) {
+	return this.getReference(ctx, "/accounts/"+userId)
+}
 
-	log.Printf("Response: %v", response)
+// getReference authorizes reference to path.
+// firebase client can't cancel requests, so ctx is checked before each of them.
+func (this FirebaseStorage) getReference(ctx context.Context, path string) (*firebase.Reference, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	idToken, err := this.tokens.token(ctx)
 	if nil != err {
-		return "", err
+		return nil, err
 	}
-	return response.IdToken, nil
+	base := this.BaseUrl
+	ref := firebase.NewReference(base + path).Auth(idToken)
+	return ref, nil
 }
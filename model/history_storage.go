@@ -0,0 +1,41 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// HistoryStorage keeps balances observed for users' accounts over time
+type HistoryStorage interface {
+	// AppendBalance adds entry unless it repeats the latest one, reports whether entry was added
+	AppendBalance(ctx context.Context, userID int, account string, entry BalanceHistoryEntry) (bool, error)
+	// GetBalanceHistory returns entries in the order they were observed
+	GetBalanceHistory(ctx context.Context, userID int, account string) ([]BalanceHistoryEntry, error)
+}
+
+// BalanceHistoryEntry is a balance observed at some moment
+type BalanceHistoryEntry struct {
+	ObservedAt time.Time       `json:"observedAt"`
+	Balance    BalanceSnapshot `json:"balance"`
+}
+
+// Equal reports whether snapshots have the same month and rows
+func (s BalanceSnapshot) Equal(other BalanceSnapshot) bool {
+	if s.Month != other.Month || len(s.Rows) != len(other.Rows) {
+		return false
+	}
+	for i := range s.Rows {
+		if s.Rows[i] != other.Rows[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendIfChanged adds entry to history unless it repeats the latest balance
+func appendIfChanged(history []BalanceHistoryEntry, entry BalanceHistoryEntry) ([]BalanceHistoryEntry, bool) {
+	if len(history) > 0 && history[len(history)-1].Balance.Equal(entry.Balance) {
+		return history, false
+	}
+	return append(history, entry), true
+}
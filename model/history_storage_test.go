@@ -0,0 +1,81 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBoltAppendBalanceSkipsRepeatedBalance(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	january := BalanceSnapshot{Month: "Январь", Rows: []BalanceRow{{Requisite: "Итого", Amount: 100}}}
+	changed := BalanceSnapshot{Month: "Январь", Rows: []BalanceRow{{Requisite: "Итого", Amount: 50}}}
+
+	for i, snapshot := range []BalanceSnapshot{january, january, changed, january} {
+		added, err := storage.AppendBalance(context.Background(), 1, "account_0", makeEntry(snapshot))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added == (i == 1) {
+			t.Errorf("Entry #%v: unexpected added=%v", i, added)
+		}
+	}
+
+	history, err := storage.GetBalanceHistory(context.Background(), 1, "account_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatal("Expected 3 entries, but got ", len(history))
+	}
+	if !history[1].Balance.Equal(changed) || !history[2].Balance.Equal(january) {
+		t.Error("Unexpected history order: ", history)
+	}
+}
+
+func TestBoltHistoryIsSeparatedByAccount(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	storage.AppendBalance(context.Background(), 1, "account_0", makeEntry(BalanceSnapshot{Month: "Январь"}))
+
+	history, err := storage.GetBalanceHistory(context.Background(), 1, "account_1")
+	if err != nil || len(history) != 0 {
+		t.Error("Expected empty history, but got ", history, err)
+	}
+}
+
+func TestBoltDeleteUserRemovesHistory(t *testing.T) {
+	storage := createTestBoltStorage(t)
+	storage.AppendBalance(context.Background(), 1, "account_0", makeEntry(BalanceSnapshot{Month: "Январь"}))
+	storage.AppendBalance(context.Background(), 1, "account_1", makeEntry(BalanceSnapshot{Month: "Январь"}))
+	storage.AppendBalance(context.Background(), 12, "account_0", makeEntry(BalanceSnapshot{Month: "Январь"}))
+
+	storage.DeleteUser(context.Background(), 1)
+
+	for _, account := range []string{"account_0", "account_1"} {
+		if history, _ := storage.GetBalanceHistory(context.Background(), 1, account); len(history) != 0 {
+			t.Error("History was not deleted for ", account)
+		}
+	}
+	if history, _ := storage.GetBalanceHistory(context.Background(), 12, "account_0"); len(history) != 1 {
+		t.Error("History of other user must stay")
+	}
+}
+
+func TestFirebaseHistoryIsOrderedByPushKeys(t *testing.T) {
+	children := map[string]BalanceHistoryEntry{
+		"-NqB2cZ0000000000002": makeEntry(BalanceSnapshot{Month: "Март"}),
+		"-NqA9xY0000000000001": makeEntry(BalanceSnapshot{Month: "Январь"}),
+		"-NqA9xY0000000000009": makeEntry(BalanceSnapshot{Month: "Февраль"}),
+	}
+
+	history := orderPushed(children)
+
+	if len(history) != 3 || history[0].Balance.Month != "Январь" || history[1].Balance.Month != "Февраль" ||
+		history[2].Balance.Month != "Март" {
+		t.Error("Unexpected history order: ", history)
+	}
+}
+
+func makeEntry(snapshot BalanceSnapshot) BalanceHistoryEntry {
+	return BalanceHistoryEntry{ObservedAt: time.Now(), Balance: snapshot}
+}
@@ -0,0 +1,23 @@
+package model
+
+import "context"
+
+// Logger writes entries of storages at their levels. ctx is the caller's one,
+// so entries keep its fields, keyvals add more, e.g. "user", 42.
+type Logger interface {
+	Info(ctx context.Context, msg string, keyvals ...interface{})
+	Error(ctx context.Context, msg string, keyvals ...interface{})
+}
+
+// nopLogger drops entries when no logger is given
+type nopLogger struct{}
+
+func (nopLogger) Info(ctx context.Context, msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(ctx context.Context, msg string, keyvals ...interface{}) {}
+
+func orNop(logger Logger) Logger {
+	if logger == nil {
+		return nopLogger{}
+	}
+	return logger
+}
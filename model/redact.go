@@ -0,0 +1,27 @@
+package model
+
+import "fmt"
+
+// Mask replaces secret values in logs
+const Mask = "******"
+
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return Mask
+}
+
+// String masks password so UserInfo is safe to log
+func (userInfo UserInfo) String() string {
+	type plainUserInfo UserInfo
+	masked := plainUserInfo(userInfo)
+	masked.Password = maskSecret(masked.Password)
+	return fmt.Sprintf("%+v", masked)
+}
+
+// String masks api key and password so storage is safe to log
+func (this FirebaseStorage) String() string {
+	return fmt.Sprintf("{BaseUrl:%v Login:%v ApiKey:%v Password:%v}",
+		this.BaseUrl, this.Login, maskSecret(this.ApiKey), maskSecret(this.Password))
+}
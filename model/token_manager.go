@@ -0,0 +1,172 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minya/googleapis"
+	"github.com/minya/goutils/web"
+)
+
+const (
+	signInURL  = "https://www.googleapis.com/identitytoolkit/v3/relyingparty/verifyPassword"
+	refreshURL = "https://securetoken.googleapis.com/v1/token"
+
+	// token is renewed this long before it actually expires
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// tokenManager caches firebase ID token and renews it when it's about to expire.
+// It is safe for concurrent use.
+type tokenManager struct {
+	apiKey     string
+	login      string
+	password   string
+	signInURL  string
+	refreshURL string
+	client     *http.Client
+	now        func() time.Time
+	logger     Logger
+
+	mu           sync.Mutex
+	idToken      string
+	refreshToken string
+	expiresAt    time.Time
+}
+
+type refreshTokenResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    string `json:"expires_in"`
+}
+
+func newTokenManager(apiKey string, login string, password string, logger Logger) *tokenManager {
+	return &tokenManager{
+		logger:     orNop(logger),
+		apiKey:     apiKey,
+		login:      login,
+		password:   password,
+		signInURL:  signInURL,
+		refreshURL: refreshURL,
+		client:     &http.Client{Transport: web.DefaultTransport(1000)},
+		now:        time.Now,
+	}
+}
+
+// token returns valid ID token signing in or refreshing the cached one if needed
+func (m *tokenManager) token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.idToken != "" && m.now().Before(m.expiresAt.Add(-tokenRefreshMargin)) {
+		return m.idToken, nil
+	}
+
+	if m.refreshToken != "" {
+		err := m.refresh(ctx)
+		if err == nil {
+			return m.idToken, nil
+		}
+		m.logger.Error(ctx, fmt.Sprintf("Unable to refresh firebase token, signing in again: %v", err))
+	}
+
+	if err := m.signIn(ctx); err != nil {
+		m.idToken = ""
+		m.refreshToken = ""
+		return "", err
+	}
+	return m.idToken, nil
+}
+
+func (m *tokenManager) signIn(ctx context.Context) error {
+	m.logger.Info(ctx, fmt.Sprintf("Signing in to firebase as %v", m.login))
+	reqBytes, err := json.Marshal(googleapis.LoginAndPasswordRequest{
+		Email:             m.login,
+		Password:          m.password,
+		ReturnSecureToken: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		m.signInURL+"?key="+url.QueryEscape(m.apiKey),
+		bytes.NewReader(reqBytes))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	var result googleapis.VerifyPasswordResponse
+	if err = readTokenResponse(response, &result); err != nil {
+		return err
+	}
+	return m.remember(result.IdToken, result.RefreshToken, result.ExpiresIn)
+}
+
+func (m *tokenManager) refresh(ctx context.Context) error {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", m.refreshToken)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		m.refreshURL+"?key="+url.QueryEscape(m.apiKey),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := m.client.Do(request)
+	if err != nil {
+		return err
+	}
+
+	var result refreshTokenResponse
+	if err = readTokenResponse(response, &result); err != nil {
+		return err
+	}
+	return m.remember(result.IDToken, result.RefreshToken, result.ExpiresIn)
+}
+
+func (m *tokenManager) remember(idToken string, refreshToken string, expiresIn string) error {
+	seconds, err := strconv.Atoi(expiresIn)
+	if err != nil {
+		return fmt.Errorf("Invalid token expiration '%v'", expiresIn)
+	}
+	if idToken == "" {
+		return fmt.Errorf("Empty ID token in response")
+	}
+	m.idToken = idToken
+	m.refreshToken = refreshToken
+	m.expiresAt = m.now().Add(time.Duration(seconds) * time.Second)
+	return nil
+}
+
+func readTokenResponse(response *http.Response, target interface{}) error {
+	defer response.Body.Close()
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 400 {
+		var errStruct googleapis.ErrorResponse
+		if err = json.Unmarshal(responseBytes, &errStruct); err != nil || errStruct.Error.Message == "" {
+			return fmt.Errorf("%v from identity service", response.StatusCode)
+		}
+		return fmt.Errorf("%v", errStruct.Error.Message)
+	}
+	return json.Unmarshal(responseBytes, target)
+}
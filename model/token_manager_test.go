@@ -0,0 +1,179 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenIsCachedUntilExpiration(t *testing.T) {
+	identity := newFakeIdentityService(t)
+	manager, clock := createTestTokenManager(identity)
+
+	first, err := manager.token(context.Background())
+	if err != nil {
+		t.Fatal("Error should not have happened: ", err)
+	}
+	clock.advance(30 * time.Minute)
+	second, _ := manager.token(context.Background())
+
+	if first != second {
+		t.Error("Expected cached token, but got new one")
+	}
+	if identity.signIns != 1 || identity.refreshes != 0 {
+		t.Errorf("Expected 1 sign in and 0 refreshes, but got %v and %v", identity.signIns, identity.refreshes)
+	}
+}
+
+func TestTokenIsRefreshedBeforeExpiration(t *testing.T) {
+	identity := newFakeIdentityService(t)
+	manager, clock := createTestTokenManager(identity)
+
+	first, _ := manager.token(context.Background())
+	clock.advance(time.Hour - tokenRefreshMargin + time.Second)
+	second, err := manager.token(context.Background())
+	if err != nil {
+		t.Fatal("Error should not have happened: ", err)
+	}
+
+	if first == second {
+		t.Error("Expected refreshed token")
+	}
+	if identity.signIns != 1 || identity.refreshes != 1 {
+		t.Errorf("Expected 1 sign in and 1 refresh, but got %v and %v", identity.signIns, identity.refreshes)
+	}
+	if identity.lastRefreshToken != "refresh_1" {
+		t.Error("Refresh token mismatch: ", identity.lastRefreshToken)
+	}
+}
+
+func TestTokenSignsInAgainIfRefreshFails(t *testing.T) {
+	identity := newFakeIdentityService(t)
+	identity.failRefresh = true
+	manager, clock := createTestTokenManager(identity)
+
+	manager.token(context.Background())
+	clock.advance(2 * time.Hour)
+	_, err := manager.token(context.Background())
+	if err != nil {
+		t.Fatal("Error should not have happened: ", err)
+	}
+	if identity.signIns != 2 {
+		t.Error("Expected 2 sign ins, but got ", identity.signIns)
+	}
+}
+
+func TestTokenReturnsSignInError(t *testing.T) {
+	identity := newFakeIdentityService(t)
+	identity.failSignIn = true
+	manager, _ := createTestTokenManager(identity)
+
+	token, err := manager.token(context.Background())
+	if err == nil || err.Error() != "INVALID_PASSWORD" {
+		t.Error("Expected INVALID_PASSWORD error, but got ", err)
+	}
+	if token != "" {
+		t.Error("Expected empty token, but got ", token)
+	}
+}
+
+func TestTokenSignsInOnceForConcurrentCallers(t *testing.T) {
+	identity := newFakeIdentityService(t)
+	manager, _ := createTestTokenManager(identity)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := manager.token(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if identity.signIns != 1 {
+		t.Error("Expected 1 sign in, but got ", identity.signIns)
+	}
+}
+
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
+
+type fakeIdentityService struct {
+	server           *httptest.Server
+	mu               sync.Mutex
+	signIns          int
+	refreshes        int
+	lastRefreshToken string
+	failSignIn       bool
+	failRefresh      bool
+}
+
+func newFakeIdentityService(t *testing.T) *fakeIdentityService {
+	identity := &fakeIdentityService{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/verifyPassword", identity.handleSignIn)
+	mux.HandleFunc("/token", identity.handleRefresh)
+	identity.server = httptest.NewServer(mux)
+	t.Cleanup(identity.server.Close)
+	return identity
+}
+
+func (f *fakeIdentityService) handleSignIn(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failSignIn {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":400,"message":"INVALID_PASSWORD"}}`)
+		return
+	}
+	f.signIns++
+	json.NewEncoder(w).Encode(map[string]string{
+		"idToken":      fmt.Sprintf("id_%v_%v", f.signIns, f.refreshes),
+		"refreshToken": fmt.Sprintf("refresh_%v", f.signIns),
+		"expiresIn":    "3600",
+	})
+}
+
+func (f *fakeIdentityService) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failRefresh {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":400,"message":"TOKEN_EXPIRED"}}`)
+		return
+	}
+	f.refreshes++
+	f.lastRefreshToken = r.FormValue("refresh_token")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id_token":      fmt.Sprintf("id_%v_%v", f.signIns, f.refreshes),
+		"refresh_token": f.lastRefreshToken,
+		"expires_in":    "3600",
+	})
+}
+
+func createTestTokenManager(identity *fakeIdentityService) (*tokenManager, *fakeClock) {
+	clock := &fakeClock{current: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)}
+	manager := newTokenManager("api_key", "login@gmail.com", "password", nil)
+	manager.signInURL = identity.server.URL + "/verifyPassword"
+	manager.refreshURL = identity.server.URL + "/token"
+	manager.client = identity.server.Client()
+	manager.now = clock.now
+	return manager, clock
+}
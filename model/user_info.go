@@ -1,14 +1,104 @@
 package model
 
+import "time"
+
 //UserInfo struct to store credentials and subscriptions
 type UserInfo struct {
 	Login         string                      `json:"login"`
 	Password      string                      `json:"password"`
 	Subscriptions map[string]SubscriptionInfo `json:"subscriptions,omitempty"`
+	Conversation  *Conversation               `json:"conversation,omitempty"`
+	Health        *CheckHealth                `json:"health,omitempty"`
+	// Language of bot messages, detected from Telegram or chosen with /lang
+	Language string `json:"language,omitempty"`
+	// Reminder about paying bills of subscribed accounts, nil if user doesn't want reminders
+	Reminder *ReminderSettings `json:"reminder,omitempty"`
+	// TimeZone is an IANA name or UTC offset like UTC+5, empty means server's time zone
+	TimeZone string `json:"timeZone,omitempty"`
+	// QuietHours hold notifications back, nil if user may be notified any time
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+}
+
+// QuietHours is a daily window in user's time zone, it may span midnight (e.g. 23 to 8)
+type QuietHours struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// ReminderSettings tells when to remind about paying the bill
+type ReminderSettings struct {
+	// DueDay is the day of month the bill must be paid by, days beyond month's end mean its last day
+	DueDay int `json:"dueDay"`
+	// DaysBefore is how many days before DueDay reminders start
+	DaysBefore int `json:"daysBefore"`
 }
 
 //SubscriptionInfo stores state and chat to notify when changes occur
 type SubscriptionInfo struct {
-	ChatID        int    `json:"chatId"`
-	LastSeenState string `json:"lastSeenState"`
+	ChatID   int              `json:"chatId"`
+	LastSeen *BalanceSnapshot `json:"lastSeen,omitempty"`
+	// Alerts narrow down changes worth a notification, without them any change is notified
+	Alerts []AlertRule `json:"alerts,omitempty"`
+	// RemindedOn is the date (2006-01-02) of the latest payment reminder
+	RemindedOn string `json:"remindedOn,omitempty"`
+	// Pending is the balance notified last while changes wait for quiet hours to end
+	Pending *BalanceSnapshot `json:"pending,omitempty"`
+}
+
+// Alert rule kinds
+const (
+	// AlertTotalAbove fires when total due exceeds Threshold
+	AlertTotalAbove = "total_above"
+	// AlertNewBill fires when a new billing month starts
+	AlertNewBill = "new_bill"
+	// AlertDebtIncrease fires when total due grows by more than Threshold
+	AlertDebtIncrease = "debt_increase"
+)
+
+// AlertRule is a condition on balance change the user wants to be notified about
+type AlertRule struct {
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// BalanceSnapshot is a balance as it was seen by the bot
+type BalanceSnapshot struct {
+	Month string       `json:"month"`
+	Rows  []BalanceRow `json:"rows,omitempty"`
+}
+
+// BalanceRow is a single requisite of balance
+type BalanceRow struct {
+	Requisite string  `json:"requisite"`
+	Amount    float64 `json:"amount"`
+}
+
+// Conversation steps
+const (
+	StepRegLogin    = "reg_login"
+	StepRegPassword = "reg_password"
+	StepAlertAmount = "alert_amount"
+)
+
+// Conversation stores progress of a multi-step dialog with user
+type Conversation struct {
+	Step  string `json:"step"`
+	Login string `json:"login,omitempty"`
+	// Account and Alert are the subscription and the rule waiting for a threshold
+	Account string `json:"account,omitempty"`
+	Alert   string `json:"alert,omitempty"`
+}
+
+// AwaitsSecret reports whether next user's message is a secret
+func (c *Conversation) AwaitsSecret() bool {
+	return c != nil && c.Step == StepRegPassword
+}
+
+// CheckHealth tracks failed balance checks of a user
+type CheckHealth struct {
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	AuthFailures        int       `json:"authFailures"`
+	NextAttempt         time.Time `json:"nextAttempt"`
+	// Paused is set when credentials stopped working, checks resume after /reg
+	Paused bool `json:"paused,omitempty"`
 }
@@ -1,8 +1,12 @@
 package model
 
-//UserStorage is to store users
+import "context"
+
+// UserStorage is to store users.
+// Implementations refuse to start an operation once ctx is done
 type UserStorage interface {
-	GetUserInfo(userID int) (UserInfo, error)
-	SaveUser(userID int, userInfo UserInfo) error
-	GetUsers() (map[int]UserInfo, error)
+	GetUserInfo(ctx context.Context, userID int) (UserInfo, error)
+	SaveUser(ctx context.Context, userID int, userInfo UserInfo) error
+	GetUsers(ctx context.Context) (map[int]UserInfo, error)
+	DeleteUser(ctx context.Context, userID int) error
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minya/telegram"
+)
+
+const pollingRetryDelay = 5 * time.Second
+
+type updatesSource interface {
+	GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]telegram.Update, error)
+	DeleteWebhook(ctx context.Context) error
+}
+
+// poller fetches updates with getUpdates long polling and feeds them to the same handler as webhook does.
+// It doesn't need a public address, so the bot can run behind NAT.
+type poller struct {
+	source     updatesSource
+	handle     func(context.Context, telegram.Update) interface{}
+	sender     replySender
+	offsets    offsetFile
+	timeout    time.Duration
+	retryDelay time.Duration
+	// health reports whether Telegram answers, may be nil
+	health *readiness
+}
+
+func createPoller(
+	bot *botAPI,
+	handle func(context.Context, telegram.Update) interface{},
+	settings PollingSettings,
+	health *readiness) poller {
+	return poller{
+		source:     bot,
+		handle:     handle,
+		sender:     bot,
+		offsets:    offsetFile{path: settings.offsetPath()},
+		timeout:    settings.timeout(),
+		retryDelay: pollingRetryDelay,
+		health:     health,
+	}
+}
+
+// Run polls updates until ctx is done. An update being processed is finished before Run returns.
+func (p poller) Run(ctx context.Context) {
+	ctx = withLogFields(ctx, "component", "poller")
+	log := loggerFrom(ctx)
+	offset, err := p.offsets.load()
+	if err != nil {
+		log.warnf("Unable to read updates offset, starting over: %v", err)
+	}
+	if err = p.source.DeleteWebhook(ctx); err != nil {
+		log.warnf("Unable to delete webhook: %v", err)
+	}
+	log.infof("Polling updates from offset %v", offset)
+
+	for ctx.Err() == nil {
+		updates, err := p.source.GetUpdates(ctx, offset, p.timeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.errorf("Unable to get updates: %v", err)
+				p.health.set(checkListener, err)
+				p.wait(ctx)
+			}
+			continue
+		}
+		p.health.set(checkListener, nil)
+		for _, upd := range updates {
+			if ctx.Err() != nil {
+				break
+			}
+			processUpdate(detach(ctx), p.handle, p.sender, upd)
+			offset = upd.UpdateId + 1
+			if err = p.offsets.save(offset); err != nil {
+				log.errorf("Unable to save updates offset: %v", err)
+			}
+		}
+	}
+	log.infof("Polling stopped at offset %v", offset)
+}
+
+func (p poller) wait(ctx context.Context) {
+	timer := time.NewTimer(p.retryDelay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// offsetFile keeps the id of the next update to fetch, so processed updates aren't fetched again after restart
+type offsetFile struct {
+	path string
+}
+
+// load returns 0 if nothing is saved yet
+func (f offsetFile) load() (int, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// save replaces the file atomically, so a crash never leaves it half-written
+func (f offsetFile) save(offset int) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(strconv.Itoa(offset)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
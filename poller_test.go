@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minya/ercInfoBot/internal/fakebotapi"
+)
+
+func TestPollerHandlesUpdatesAndSavesOffset(t *testing.T) {
+	api := newFakeBotAPI(t)
+	api.PushUpdate(makeMsgUpdate("/help"))
+	api.PushUpdate(makeMsgUpdate("/help"))
+	offsets := offsetFile{path: filepath.Join(t.TempDir(), "offset")}
+
+	runPollerUntil(t, api, offsets, nil, func() bool { return len(api.Messages()) == 2 })
+
+	if offset, _ := offsets.load(); offset != 3 {
+		t.Error("Expected offset 3, but got ", offset)
+	}
+	if calls := api.Calls(); calls[0].Method != "deleteWebhook" {
+		t.Error("Webhook must be deleted before polling, but first call is ", calls[0].Method)
+	}
+}
+
+func TestPollerResumesFromSavedOffset(t *testing.T) {
+	api := newFakeBotAPI(t)
+	api.PushUpdate(makeMsgUpdate("/help"))
+	api.PushUpdate(makeMsgUpdate("/help"))
+	offsets := offsetFile{path: filepath.Join(t.TempDir(), "offset")}
+	if err := offsets.save(2); err != nil {
+		t.Fatal(err)
+	}
+
+	runPollerUntil(t, api, offsets, nil, func() bool { return len(api.Messages()) > 0 })
+
+	if count := len(api.Messages()); count != 1 {
+		t.Error("Already processed update was handled again, replies: ", count)
+	}
+}
+
+func TestPollerRetriesAfterError(t *testing.T) {
+	api := newFakeBotAPI(t)
+	api.FailNext("getUpdates", 2)
+	api.PushUpdate(makeMsgUpdate("/help"))
+	offsets := offsetFile{path: filepath.Join(t.TempDir(), "offset")}
+	ready := newReadiness(checkListener)
+
+	runPollerUntil(t, api, offsets, ready, func() bool { return len(api.Messages()) == 1 })
+
+	ready.mu.Lock()
+	defer ready.mu.Unlock()
+	if err := ready.checks[checkListener]; err != nil {
+		t.Error("Expected listener to be ready after recovery, but got ", err)
+	}
+}
+
+func TestOffsetFileLoadReturnsZeroIfMissing(t *testing.T) {
+	offset, err := offsetFile{path: filepath.Join(t.TempDir(), "offset")}.load()
+	if err != nil || offset != 0 {
+		t.Error("Expected 0, but got ", offset, err)
+	}
+}
+
+// runPollerUntil polls fake Bot API until done reports true
+func runPollerUntil(t *testing.T, api *fakebotapi.Server, offsets offsetFile, health *readiness, done func() bool) {
+	bot := newTestBotAPI(api)
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, bot)
+	p := poller{
+		source:     bot,
+		handle:     h.handle,
+		sender:     bot,
+		offsets:    offsets,
+		timeout:    time.Second,
+		retryDelay: time.Millisecond,
+		health:     health,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := runInBackground(func() { p.Run(ctx) })
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	waitFor(t, done)
+}
+
+func newFakeBotAPI(t *testing.T) *fakebotapi.Server {
+	api := fakebotapi.New("token")
+	t.Cleanup(api.Close)
+	return api
+}
+
+// newTestBotAPI makes bot client calling fake Bot API
+func newTestBotAPI(api *fakebotapi.Server) *botAPI {
+	bot := newBotAPI(api.Token)
+	bot.baseURL = api.URL()
+	return bot
+}
+
+// waitFor fails test if condition doesn't become true in a few seconds
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition is not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// time zones of users must load even where the system has no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+const (
+	// quietOff turns quiet hours off
+	quietOff          = "off"
+	defaultQuietStart = 23
+	defaultQuietEnd   = 8
+)
+
+// timeZones are offered by /timezone buttons
+var timeZones = []string{
+	"Europe/Kaliningrad", "Europe/Moscow", "Europe/Samara", "Asia/Yekaterinburg", "Asia/Omsk", "Asia/Novosibirsk",
+	"Asia/Krasnoyarsk", "Asia/Irkutsk", "Asia/Yakutsk", "Asia/Vladivostok", "Asia/Magadan", "Asia/Kamchatka",
+}
+
+// quietWindows are offered by /quiet buttons as start and end hours
+var quietWindows = [][2]int{{23, 8}, {22, 7}, {0, 9}}
+
+// parseTimeZone accepts IANA names and UTC offsets like UTC+5 or UTC-03:30
+func parseTimeZone(name string) (*time.Location, error) {
+	if offset := strings.TrimPrefix(strings.ToUpper(name), "UTC"); offset != strings.ToUpper(name) && offset != "" {
+		sign := 1
+		switch offset[0] {
+		case '+':
+		case '-':
+			sign = -1
+		default:
+			return nil, fmt.Errorf("Bad UTC offset %v", name)
+		}
+		hoursText, minutesText := offset[1:], "0"
+		if i := strings.Index(hoursText, ":"); i >= 0 {
+			hoursText, minutesText = hoursText[:i], hoursText[i+1:]
+		}
+		hours, errHours := strconv.Atoi(hoursText)
+		minutes, errMinutes := strconv.Atoi(minutesText)
+		if errHours != nil || errMinutes != nil || hours > 14 || minutes < 0 || minutes >= 60 {
+			return nil, fmt.Errorf("Bad UTC offset %v", name)
+		}
+		return time.FixedZone(name, sign*(hours*3600+minutes*60)), nil
+	}
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("Unknown time zone %v", name)
+	}
+	return time.LoadLocation(name)
+}
+
+func isTimeZone(arg string) bool {
+	_, err := parseTimeZone(arg)
+	return err == nil
+}
+
+// userLocation is user's time zone or server's one if user hasn't chosen any
+func userLocation(userInfo model.UserInfo) *time.Location {
+	if userInfo.TimeZone == "" {
+		return time.Local
+	}
+	location, err := parseTimeZone(userInfo.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return location
+}
+
+// inQuietHours reports whether t falls into user's quiet hours
+func inQuietHours(userInfo model.UserInfo, t time.Time) bool {
+	quiet := userInfo.QuietHours
+	if quiet == nil || quiet.Start == quiet.End {
+		return false
+	}
+	hour := t.In(userLocation(userInfo)).Hour()
+	if quiet.Start < quiet.End {
+		return hour >= quiet.Start && hour < quiet.End
+	}
+	return hour >= quiet.Start || hour < quiet.End
+}
+
+// configureTimeZone shows user's time zone and changes it
+func (h *handler) configureTimeZone(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, args []string, tr translator) interface{} {
+	if argAt(args, 0) == "" {
+		keyboard := make([][]telegram.InlineKeyboardButton, 0, len(timeZones))
+		for _, name := range timeZones {
+			keyboard = append(keyboard, []telegram.InlineKeyboardButton{
+				{Text: describeTimeZone(name), CallbackData: "/timezone " + name},
+			})
+		}
+		return telegram.ReplyMessage{
+			ChatId:      getReplyToChatID(upd),
+			Text:        tr.text("timezone.choose", timeZoneName(userInfo, tr)),
+			ReplyMarkup: telegram.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+		}
+	}
+
+	userInfo.TimeZone = args[0]
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	now := time.Now().In(userLocation(userInfo))
+	return replyWithMessage(upd, tr.text("timezone.set", userInfo.TimeZone, now.Format("15:04")))
+}
+
+// describeTimeZone shows zone with its current offset, e.g. "Asia/Yekaterinburg (UTC+5)"
+func describeTimeZone(name string) string {
+	location, err := parseTimeZone(name)
+	if err != nil {
+		return name
+	}
+	_, offset := time.Now().In(location).Zone()
+	text := fmt.Sprintf("UTC%+d", offset/3600)
+	if minutes := offset % 3600 / 60; minutes != 0 {
+		text += fmt.Sprintf(":%02d", abs(minutes))
+	}
+	return fmt.Sprintf("%v (%v)", name, text)
+}
+
+func timeZoneName(userInfo model.UserInfo, tr translator) string {
+	if userInfo.TimeZone == "" {
+		return tr.text("timezone.default")
+	}
+	return userInfo.TimeZone
+}
+
+// configureQuietHours shows quiet hours and changes them:
+// "/quiet 23 8" holds notifications from 23:00 till 8:00, "/quiet off" turns quiet hours off.
+func (h *handler) configureQuietHours(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, args []string, tr translator) interface{} {
+	start, end := argAt(args, 0), argAt(args, 1)
+	switch start {
+	case "":
+		return quietHoursMenu(upd, userInfo, tr)
+	case quietOff:
+		userInfo.QuietHours = nil
+	default:
+		quiet := model.QuietHours{End: defaultQuietEnd}
+		if userInfo.QuietHours != nil {
+			quiet.End = userInfo.QuietHours.End
+		}
+		quiet.Start, _ = parseHour(start)
+		if end != "" {
+			quiet.End, _ = parseHour(end)
+		}
+		userInfo.QuietHours = &quiet
+	}
+
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return quietHoursMenu(upd, userInfo, tr)
+}
+
+// quietHoursMenu offers usual windows while quiet hours are off and turning them off when they are on
+func quietHoursMenu(upd telegram.Update, userInfo model.UserInfo, tr translator) telegram.ReplyMessage {
+	var text string
+	var row []telegram.InlineKeyboardButton
+	if quiet := userInfo.QuietHours; quiet == nil {
+		text = tr.text("quiet.disabled")
+		for _, window := range quietWindows {
+			row = append(row, telegram.InlineKeyboardButton{
+				Text:         fmt.Sprintf("%02d:00–%02d:00", window[0], window[1]),
+				CallbackData: fmt.Sprintf("/quiet %v %v", window[0], window[1]),
+			})
+		}
+	} else {
+		text = tr.text("quiet.enabled", quiet.Start, quiet.End, timeZoneName(userInfo, tr))
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         tr.text("quiet.offButton"),
+			CallbackData: "/quiet " + quietOff,
+		})
+	}
+	return telegram.ReplyMessage{
+		ChatId:      getReplyToChatID(upd),
+		Text:        text,
+		ReplyMarkup: telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{row}},
+	}
+}
+
+// parseHour accepts hours like 8, 08 or 08:00
+func parseHour(arg string) (int, error) {
+	hour, err := strconv.Atoi(strings.TrimSuffix(arg, ":00"))
+	if err != nil {
+		return 0, err
+	}
+	if hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("Hour out of range: %v", arg)
+	}
+	return hour, nil
+}
+
+func isHour(arg string) bool {
+	_, err := parseHour(arg)
+	return err == nil
+}
+
+func isQuietStart(arg string) bool {
+	return arg == quietOff || isHour(arg)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
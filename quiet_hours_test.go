@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestParseTimeZone(t *testing.T) {
+	offsets := map[string]int{"UTC+5": 5 * 3600, "utc-03:30": -(3*3600 + 30*60), "UTC": 0, "Asia/Yekaterinburg": 5 * 3600}
+	for name, expected := range offsets {
+		location, err := parseTimeZone(name)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+		if _, offset := time.Date(2024, 1, 1, 0, 0, 0, 0, location).Zone(); offset != expected {
+			t.Errorf("%v: expected offset %v, but got %v", name, expected, offset)
+		}
+	}
+	for _, name := range []string{"", "Local", "UTC+", "UTC*5", "UTC+15", "UTC+5:60", "Mars/Olympus"} {
+		if _, err := parseTimeZone(name); err == nil {
+			t.Error("Expected error for ", name)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	overnight := model.UserInfo{TimeZone: "UTC+5", QuietHours: &model.QuietHours{Start: 23, End: 8}}
+	daytime := model.UserInfo{TimeZone: "UTC", QuietHours: &model.QuietHours{Start: 13, End: 15}}
+	cases := []struct {
+		userInfo model.UserInfo
+		utc      string
+		quiet    bool
+	}{
+		{overnight, "17:59", false},
+		{overnight, "18:00", true},
+		{overnight, "22:00", true},
+		{overnight, "02:59", true},
+		{overnight, "03:00", false},
+		{daytime, "12:59", false},
+		{daytime, "13:00", true},
+		{daytime, "15:00", false},
+		{model.UserInfo{}, "03:00", false},
+	}
+	for _, c := range cases {
+		if quiet := inQuietHours(c.userInfo, utcTime(t, "2024-01-10", c.utc)); quiet != c.quiet {
+			t.Errorf("%v at %v: expected %v, but got %v", c.userInfo.QuietHours, c.utc, c.quiet, quiet)
+		}
+	}
+}
+
+func TestNotifierQueuesChangesDuringQuietHours(t *testing.T) {
+	storage := createQuietNotifierStorage()
+	sender := &fakeSender{}
+	clock := utcTime(t, "2024-01-10", "20:00")
+	check := func(total float64) {
+		n := createTestNotifier(storage, createBalanceClient("Январь", total), sender)
+		n.now = func() time.Time { return clock }
+		n.runCycle(context.Background())
+	}
+
+	check(250)
+	check(300)
+	ensureNoMessages(t, sender)
+	sub := storage.userInfo.Subscriptions["account_0"]
+	if sub.Pending == nil || sub.Pending.Rows[0].Amount != 100 || sub.LastSeen.Rows[0].Amount != 300 {
+		t.Fatal("Expected queued change from 100 to 300, but got ", sub.Pending, sub.LastSeen)
+	}
+
+	clock = utcTime(t, "2024-01-11", "03:30")
+	check(300)
+	check(300)
+
+	if len(sender.messages) != 1 || !strings.Contains(sender.messages[0].Text, "Итого: 100 → 300 (+200.00)") {
+		t.Fatal("Expected one merged notification, but got ", sender.messages)
+	}
+	if storage.userInfo.Subscriptions["account_0"].Pending != nil {
+		t.Error("Queue must be emptied")
+	}
+}
+
+func TestNotifierDropsQueuedChangesCancellingOut(t *testing.T) {
+	storage := createQuietNotifierStorage()
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 250), sender)
+	n.now = func() time.Time { return utcTime(t, "2024-01-10", "20:00") }
+	n.runCycle(context.Background())
+
+	n = createTestNotifier(storage, createBalanceClient("Январь", 100), sender)
+	n.now = func() time.Time { return utcTime(t, "2024-01-11", "03:30") }
+	n.runCycle(context.Background())
+
+	ensureNoMessages(t, sender)
+	if storage.userInfo.Subscriptions["account_0"].Pending != nil {
+		t.Error("Queue must be emptied")
+	}
+}
+
+func TestNotifierPostponesRemindersDuringQuietHours(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 1500.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.TimeZone = "UTC+5"
+	storage.userInfo.QuietHours = &model.QuietHours{Start: 23, End: 8}
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 1500), sender)
+
+	n.now = func() time.Time { return utcTime(t, "2024-01-17", "20:00") }
+	n.runCycle(context.Background())
+	ensureNoMessages(t, sender)
+
+	n.now = func() time.Time { return utcTime(t, "2024-01-18", "04:00") }
+	n.runCycle(context.Background())
+	if len(sender.messages) != 1 || !strings.Contains(sender.messages[0].Text, "20.01.2024") {
+		t.Error("Expected reminder after quiet hours, but got ", sender.messages)
+	}
+	if remindedOn := storage.userInfo.Subscriptions["account_0"].RemindedOn; remindedOn != "2024-01-18" {
+		t.Error("Expected reminder date in user's time zone, but got ", remindedOn)
+	}
+}
+
+func TestQuietCommandConfiguresQuietHours(t *testing.T) {
+	storage := createFakeStorage()
+	h := createAlertTestHandler(storage)
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/quiet"))
+	buttons := reply.(telegram.ReplyMessage).ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+	if len(buttons[0]) != len(quietWindows) || buttons[0][0].CallbackData != "/quiet 23 8" {
+		t.Error("Expected window buttons, but got ", buttons)
+	}
+
+	reply = h.handle(context.Background(), makeCallbackUpdate("/quiet 22:00 07"))
+	if quiet := storage.userInfo.QuietHours; quiet == nil || *quiet != (model.QuietHours{Start: 22, End: 7}) {
+		t.Fatal("Unexpected quiet hours: ", quiet)
+	}
+	if msg := reply.(telegram.ReplyMessage); !strings.Contains(msg.Text, "с 22:00 до 07:00") {
+		t.Error("Unexpected reply: ", msg.Text)
+	}
+
+	h.handle(context.Background(), makeCallbackUpdate("/quiet off"))
+	if storage.userInfo.QuietHours != nil {
+		t.Error("Quiet hours must be turned off")
+	}
+	if _, err := ParseCommand("/quiet 24 8"); err == nil {
+		t.Error("Expected error for hour out of range")
+	}
+}
+
+func TestTimeZoneCommandSavesTimeZone(t *testing.T) {
+	storage := createFakeStorage()
+	h := createAlertTestHandler(storage)
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/timezone"))
+	buttons := reply.(telegram.ReplyMessage).ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+	if len(buttons) != len(timeZones) || buttons[3][0].Text != "Asia/Yekaterinburg (UTC+5)" {
+		t.Error("Unexpected buttons: ", buttons)
+	}
+
+	h.handle(context.Background(), makeCallbackUpdate("/timezone Asia/Yekaterinburg"))
+	if storage.userInfo.TimeZone != "Asia/Yekaterinburg" {
+		t.Error("Unexpected time zone: ", storage.userInfo.TimeZone)
+	}
+	if _, err := ParseCommand("/timezone Mars/Olympus"); err == nil {
+		t.Error("Expected error for unknown time zone")
+	}
+}
+
+// createQuietNotifierStorage makes user with 100 rubles seen and quiet hours from 23 till 8 at UTC+5
+func createQuietNotifierStorage() *fakeStorage {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.TimeZone = "UTC+5"
+	storage.userInfo.QuietHours = &model.QuietHours{Start: 23, End: 8}
+	return storage
+}
+
+func utcTime(t *testing.T, date string, clock string) time.Time {
+	parsed, err := time.Parse("2006-01-02 15:04", date+" "+clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
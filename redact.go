@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+// redactCommandText masks secret arguments in a raw command text.
+// Text which can't be tokenized is masked entirely after the command name.
+func redactCommandText(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return text
+	}
+	cmd := commandName(fields[0])
+	if !hasSecretArgs(cmd) {
+		return text
+	}
+	tokens, err := tokenize(text)
+	if err != nil {
+		return fields[0] + " " + model.Mask
+	}
+	for i := 1; i < len(tokens); i++ {
+		if isSecretArg(cmd, i-1) {
+			tokens[i] = model.Mask
+		}
+	}
+	return strings.Join(tokens, " ")
+}
+
+// redactUpdate returns copy of update safe to log.
+// secretMessage tells that the whole message text is a secret (e.g. password step of registration)
+func redactUpdate(upd telegram.Update, secretMessage bool) telegram.Update {
+	if secretMessage && upd.Message.Text != "" {
+		upd.Message.Text = model.Mask
+	}
+	upd.Message.Text = redactCommandText(upd.Message.Text)
+	upd.CallbackQuery.Data = redactCommandText(upd.CallbackQuery.Data)
+	upd.CallbackQuery.Message.Text = redactCommandText(upd.CallbackQuery.Message.Text)
+	return upd
+}
+
+func (cmd Command) String() string {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		if isSecretArg(cmd.Command, i) {
+			arg = model.Mask
+		}
+		args[i] = arg
+	}
+	return "{" + cmd.Command + " [" + strings.Join(args, " ") + "]}"
+}
+
+// redactingWriter masks known secret values in everything written through it.
+// It is a safety net for secrets known at startup (tokens, keys, passwords from settings).
+type redactingWriter struct {
+	mu      sync.RWMutex
+	out     io.Writer
+	secrets []string
+}
+
+func newRedactingWriter(out io.Writer) *redactingWriter {
+	return &redactingWriter{out: out}
+}
+
+func (w *redactingWriter) addSecrets(secrets ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, secret := range secrets {
+		if secret != "" {
+			w.secrets = append(w.secrets, secret)
+		}
+	}
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	text := string(p)
+	for _, secret := range w.secrets {
+		text = strings.ReplaceAll(text, secret, model.Mask)
+	}
+	if _, err := io.WriteString(w.out, text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/minya/ercInfoBot/model"
+)
+
+const secretPassword = "qwe123QWE!@#"
+
+func TestRegisterNeverLogsPassword(t *testing.T) {
+	logged := captureLog(t)
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+
+	h.handle(context.Background(), makeMsgUpdate("/reg login@gmail.com "+secretPassword))
+	h.handle(context.Background(), makeCallbackUpdate("/reg login@gmail.com "+secretPassword))
+
+	ensureNoSecret(t, logged.String(), secretPassword)
+	if !strings.Contains(logged.String(), "login@gmail.com") {
+		t.Error("Login is expected to be logged")
+	}
+}
+
+func TestHandleNeverLogsStoredPassword(t *testing.T) {
+	logged := captureLog(t)
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	storage := &fakeStorage{userInfo: model.UserInfo{Login: "login@gmail.com", Password: secretPassword}}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+
+	h.handle(context.Background(), makeMsgUpdate("/get"))
+
+	ensureNoSecret(t, logged.String(), secretPassword)
+}
+
+func TestRedactCommandText(t *testing.T) {
+	cases := map[string]string{
+		"/reg login " + secretPassword:         "/reg login " + model.Mask,
+		"/reg login " + secretPassword + " 12": "/reg login " + model.Mask + " 12",
+		"/get account_0":                       "/get account_0",
+		"":                                     "",
+	}
+	for text, expected := range cases {
+		if got := redactCommandText(text); got != expected {
+			t.Errorf("expected %v, but got %v", expected, got)
+		}
+	}
+}
+
+func TestCommandStringMasksSecretArgs(t *testing.T) {
+	cmd, _ := ParseCommand("/reg login " + secretPassword)
+	ensureNoSecret(t, fmt.Sprintf("%v", cmd), secretPassword)
+}
+
+func TestSettingsStringMasksSecrets(t *testing.T) {
+	settings := BotSettings{
+		ID:                "bot_token",
+		UpdateCheckPeriod: "1h",
+		StorageSettings: FirebaseSettings{
+			BaseURL:  "https://base.url",
+			APIKey:   "api_key",
+			Login:    "firebase@gmail.com",
+			Password: "firebase_password",
+		},
+		Encryption: EncryptionSettings{Key: "encryption_key", PreviousKeys: []string{"old_key"}},
+		Webhook:    WebhookSettings{SecretToken: "webhook_secret"},
+	}
+	text := fmt.Sprintf("%v", settings)
+	for _, secret := range settings.secrets() {
+		ensureNoSecret(t, text, secret)
+	}
+}
+
+func TestUserInfoStringMasksPassword(t *testing.T) {
+	userInfo := model.UserInfo{Login: "login@gmail.com", Password: secretPassword}
+	ensureNoSecret(t, fmt.Sprintf("%v", userInfo), secretPassword)
+	ensureNoSecret(t, fmt.Sprintf("%v", map[int]model.UserInfo{1: userInfo}), secretPassword)
+}
+
+func TestRedactingWriterMasksKnownSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	writer := newRedactingWriter(&buf)
+	writer.addSecrets("bot_token", "")
+	logger := log.New(writer, "", 0)
+
+	logger.Printf("https://api.telegram.org/botbot_token/sendMessage")
+
+	ensureNoSecret(t, buf.String(), "bot_token")
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := rootLogger
+	rootLogger = newLogger(&buf, levelDebug, formatLogfmt)
+	log.SetOutput(&buf)
+	t.Cleanup(func() {
+		rootLogger = previous
+		log.SetOutput(os.Stderr)
+	})
+	return &buf
+}
+
+func ensureNoSecret(t *testing.T, text string, secret string) {
+	if strings.Contains(text, secret) {
+		t.Errorf("Secret %v leaked: %v", secret, text)
+	}
+}
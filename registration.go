@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func (h *handler) startRegistration(ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, tr translator) interface{} {
+	userInfo.Conversation = &model.Conversation{Step: model.StepRegLogin}
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return telegram.ReplyMessage{
+		ChatId: getReplyToChatID(upd),
+		Text:   tr.text("reg.askLogin"),
+	}
+}
+
+// continuesConversation tells whether text answers the conversation's question rather than is a command.
+// A password may start with "/", so while it's awaited only /cancel is taken as a command.
+func continuesConversation(conversation *model.Conversation, text string) bool {
+	if conversation == nil {
+		return false
+	}
+	if !strings.HasPrefix(text, "/") {
+		return true
+	}
+	return conversation.AwaitsSecret() && commandName(strings.Fields(text)[0]) != "/cancel"
+}
+
+func (h *handler) continueConversation(ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, tr translator) interface{} {
+	text := strings.TrimSpace(upd.Message.Text)
+	conversation := *userInfo.Conversation
+
+	switch conversation.Step {
+	case model.StepRegLogin:
+		if text == "" {
+			return replyWithMessage(upd, tr.text("reg.emptyLogin"))
+		}
+		userInfo.Conversation = &model.Conversation{Step: model.StepRegPassword, Login: text}
+		if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+			loggerFrom(ctx).errorf("Error while saving user: %v", err)
+			return replyWithMessage(upd, tr.text("error"))
+		}
+		return telegram.ReplyMessage{
+			ChatId: getReplyToChatID(upd),
+			Text:   tr.text("reg.askPassword"),
+		}
+	case model.StepRegPassword:
+		h.deleteMessage(ctx, upd)
+		userInfo.Conversation = nil
+		if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+			loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		}
+		return h.register(ctx, upd, userID, userInfo, conversation.Login, text, tr)
+	case model.StepAlertAmount:
+		return h.continueAlertConversation(ctx, upd, userID, userInfo, text, tr)
+	}
+
+	loggerFrom(ctx).warnf("Unknown conversation step %v. Reset.", conversation.Step)
+	return h.cancelConversation(ctx, upd, userID, userInfo, tr)
+}
+
+func (h *handler) cancelConversation(ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, tr translator) interface{} {
+	if userInfo.Conversation == nil {
+		return replyWithMessage(upd, tr.text("cancel.nothing"))
+	}
+	userInfo.Conversation = nil
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return replyWithMessage(upd, tr.text("cancelled"))
+}
+
+// deleteMessage removes user's message (e.g. with credentials) from chat history
+func (h *handler) deleteMessage(ctx context.Context, upd telegram.Update) {
+	if upd.Message.MessageId == 0 {
+		return
+	}
+	if err := h.bot.DeleteMessage(ctx, upd.Message.Chat.Id, upd.Message.MessageId); err != nil {
+		loggerFrom(ctx).warnf("Unable to delete message %v: %v", upd.Message.MessageId, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestRegistrationWizard(t *testing.T) {
+	logged := captureLog(t)
+	storage := &fakeStorage{}
+	bot := &fakeBot{}
+	var usedLogin, usedPassword string
+	var makeClient = func(l string, p string) ercclient {
+		usedLogin, usedPassword = l, p
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, bot)
+
+	h.handle(context.Background(), makeMsgUpdate("/reg"))
+	ensureConversationStep(t, storage.userInfo, model.StepRegLogin)
+
+	h.handle(context.Background(), makeMsgUpdate("login@gmail.com"))
+	ensureConversationStep(t, storage.userInfo, model.StepRegPassword)
+
+	passwordUpd := makeMsgUpdate(secretPassword)
+	passwordUpd.Message.MessageId = 42
+	reply := h.handle(context.Background(), passwordUpd).(telegram.ReplyMessage)
+
+	if !strings.Contains(reply.Text, "Личный кабинет подключен") {
+		t.Error("Expected registration confirmation, but got ", reply.Text)
+	}
+	if usedLogin != "login@gmail.com" || usedPassword != secretPassword {
+		t.Error("Credentials mismatch: ", usedLogin)
+	}
+	if storage.userInfo.Login != "login@gmail.com" || storage.userInfo.Password != secretPassword {
+		t.Error("Credentials were not saved")
+	}
+	if storage.userInfo.Conversation != nil {
+		t.Error("Conversation must be finished")
+	}
+	if len(bot.deleted) != 1 || bot.deleted[0] != 42 {
+		t.Error("Password message must be deleted, but deleted ", bot.deleted)
+	}
+	ensureNoSecret(t, logged.String(), secretPassword)
+}
+
+func TestRegistrationAcceptsPasswordStartingWithSlash(t *testing.T) {
+	const password = "/Secr3t!pw"
+	logged := captureLog(t)
+	storage := &fakeStorage{}
+	bot := &fakeBot{}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, bot)
+	h.handle(context.Background(), makeMsgUpdate("/reg"))
+	h.handle(context.Background(), makeMsgUpdate("login@gmail.com"))
+
+	passwordUpd := makeMsgUpdate(password)
+	passwordUpd.Message.MessageId = 42
+	reply := h.handle(context.Background(), passwordUpd).(telegram.ReplyMessage)
+
+	if !strings.Contains(reply.Text, "Личный кабинет подключен") || storage.userInfo.Password != password {
+		t.Error("Expected registration with the password, but got ", reply.Text)
+	}
+	if len(bot.deleted) != 1 || bot.deleted[0] != 42 {
+		t.Error("Password message must be deleted, but deleted ", bot.deleted)
+	}
+	ensureNoSecret(t, logged.String(), password)
+}
+
+func TestRegistrationSurvivesRestart(t *testing.T) {
+	storage := &fakeStorage{}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	before := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+	before.handle(context.Background(), makeMsgUpdate("/reg"))
+	before.handle(context.Background(), makeMsgUpdate("login@gmail.com"))
+
+	after := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+	after.handle(context.Background(), makeMsgUpdate(secretPassword))
+
+	if storage.userInfo.Login != "login@gmail.com" || storage.userInfo.Password != secretPassword {
+		t.Error("Credentials were not saved")
+	}
+}
+
+func TestRegistrationCancel(t *testing.T) {
+	var doTest = func(t *testing.T, steps []string) {
+		storage := &fakeStorage{}
+		var makeClient = func(l string, p string) ercclient {
+			return createFakeERCClient(1)
+		}
+		h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+		for _, step := range steps {
+			h.handle(context.Background(), makeMsgUpdate(step))
+		}
+
+		reply := h.handle(context.Background(), makeMsgUpdate("/cancel")).(telegram.ReplyMessage)
+
+		if storage.userInfo.Conversation != nil {
+			t.Error("Conversation must be cancelled")
+		}
+		if reply.Text != "Отменено" {
+			t.Error("Unexpected reply: ", reply.Text)
+		}
+		if storage.userInfo.Login != "" {
+			t.Error("Login must not be saved")
+		}
+	}
+
+	doTest(t, []string{"/reg"})
+	doTest(t, []string{"/reg", "login@gmail.com"})
+}
+
+func TestRegistrationWithWrongPasswordIsNotSaved(t *testing.T) {
+	storage := &fakeStorage{}
+	var makeClient = func(l string, p string) ercclient {
+		client := createFakeERCClient(0)
+		client.accountsErr = fmt.Errorf("Authentication error")
+		return client
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, &fakeBot{})
+	// language detected on the first update is remembered
+	regUpd := makeMsgUpdate("/reg")
+	regUpd.Message.From.LanguageCode = "en-US"
+	h.handle(context.Background(), regUpd)
+	h.handle(context.Background(), makeMsgUpdate("login@gmail.com"))
+	reply := h.handle(context.Background(), makeMsgUpdate(secretPassword)).(telegram.ReplyMessage)
+
+	if !strings.Contains(reply.Text, "Wrong login or password") {
+		t.Error("Unexpected reply: ", reply.Text)
+	}
+	if storage.userInfo.Password != "" || storage.userInfo.Conversation != nil {
+		t.Error("Wrong credentials must not be saved: ", storage.userInfo)
+	}
+}
+
+func TestInlineRegistrationDeletesMessage(t *testing.T) {
+	storage := &fakeStorage{}
+	bot := &fakeBot{}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(storage, newFakeHistory(), makeClient, bot)
+	upd := makeMsgUpdate("/reg login@gmail.com " + secretPassword)
+	upd.Message.MessageId = 7
+
+	h.handle(context.Background(), upd)
+
+	if storage.userInfo.Password != secretPassword {
+		t.Error("Credentials were not saved")
+	}
+	if len(bot.deleted) != 1 || bot.deleted[0] != 7 {
+		t.Error("Message with password must be deleted, but deleted ", bot.deleted)
+	}
+}
+
+func ensureConversationStep(t *testing.T, userInfo model.UserInfo, step string) {
+	if userInfo.Conversation == nil || userInfo.Conversation.Step != step {
+		t.Errorf("Expected conversation step %v, but got %v", step, userInfo.Conversation)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+const (
+	// remindOff turns reminders off
+	remindOff         = "off"
+	defaultDaysBefore = 3
+	maxDaysBefore     = 27
+	dateLayout        = "2006-01-02"
+)
+
+// dueDays and daysBeforeOptions are offered by /remind buttons
+var (
+	dueDays           = []int{10, 15, 20, 25}
+	daysBeforeOptions = []int{1, 3, 7}
+)
+
+// reminderDue tells whether to remind today and what due date to remind about.
+// Reminders start DaysBefore the due day and go on daily till the end of the month or the next window,
+// so an overdue bill keeps being reminded while it isn't paid.
+func reminderDue(settings model.ReminderSettings, today time.Time) (time.Time, bool) {
+	due := dueDate(today, 0, settings.DueDay)
+	if !today.After(due) {
+		return due, !today.Before(due.AddDate(0, 0, -settings.DaysBefore))
+	}
+	next := dueDate(today, 1, settings.DueDay)
+	if !today.Before(next.AddDate(0, 0, -settings.DaysBefore)) {
+		return next, true
+	}
+	return due, true
+}
+
+// dueDate is the due day of the month monthOffset months after today's one
+func dueDate(today time.Time, monthOffset int, dueDay int) time.Time {
+	year, month := today.Year(), today.Month()+time.Month(monthOffset)
+	if lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, today.Location()).Day(); dueDay > lastDay {
+		dueDay = lastDay
+	}
+	return time.Date(year, month, dueDay, 0, 0, 0, 0, today.Location())
+}
+
+// dueReminder makes a reminder about outstanding debt once a day within reminder window, days are user's local ones.
+// It returns the date of the latest reminder for the caller to save along with the rest of the subscription
+// before the reminder is sent, so it's not sent to the user who has unsubscribed meanwhile.
+func (n notifier) dueReminder(
+	ctx context.Context, account erclib.Account, sub model.SubscriptionInfo, userInfo model.UserInfo,
+	balance model.BalanceSnapshot) (telegram.ReplyMessage, string, bool) {
+	if userInfo.Reminder == nil {
+		return telegram.ReplyMessage{}, sub.RemindedOn, false
+	}
+	debt, known := totalDue(balance)
+	if !known {
+		loggerFrom(ctx).debugf("Total due is unknown")
+		return telegram.ReplyMessage{}, sub.RemindedOn, false
+	}
+	if debt < amountEpsilon {
+		loggerFrom(ctx).debugf("Nothing to pay")
+		return telegram.ReplyMessage{}, sub.RemindedOn, false
+	}
+	if inQuietHours(userInfo, n.now()) {
+		loggerFrom(ctx).debugf("Quiet hours. Reminder is postponed.")
+		return telegram.ReplyMessage{}, sub.RemindedOn, false
+	}
+	now := n.now().In(userLocation(userInfo))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	due, ok := reminderDue(*userInfo.Reminder, today)
+	if !ok || sub.RemindedOn == today.Format(dateLayout) {
+		return telegram.ReplyMessage{}, sub.RemindedOn, false
+	}
+
+	tr := newTranslator(userInfo.Language)
+	text := tr.text("remind.upcoming", due.Format("02.01.2006"), debt, account.Number, account.Address)
+	if today.After(due) {
+		text = tr.text("remind.overdue", due.Format("02.01.2006"), debt, account.Number, account.Address)
+	}
+	loggerFrom(ctx).infof("Reminds to pay %v by %v", debt, due.Format(dateLayout))
+	msg := telegram.ReplyMessage{ChatId: sub.ChatID, Text: text, ReplyMarkup: replyButtons()}
+	return msg, today.Format(dateLayout), true
+}
+
+// sendReminder sends reminder which date is already saved, a failed one is sent again the next day
+func (n notifier) sendReminder(ctx context.Context, reminder telegram.ReplyMessage) {
+	err := n.sender.SendMessage(ctx, reminder)
+	remindersSent.inc(outcomeOf(err))
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to send reminder: %v", err)
+	}
+}
+
+// configureReminder shows reminder settings and changes them:
+// "/remind 20" reminds about paying by the 20th, "/remind 20 5" starts 5 days before, "/remind off" stops.
+func (h *handler) configureReminder(
+	ctx context.Context, upd telegram.Update, userID int, userInfo model.UserInfo, args []string, tr translator) interface{} {
+	day, daysBefore := argAt(args, 0), argAt(args, 1)
+	switch day {
+	case "":
+		return reminderMenu(upd, userInfo.Reminder, tr)
+	case remindOff:
+		userInfo.Reminder = nil
+	default:
+		reminder := model.ReminderSettings{DaysBefore: defaultDaysBefore}
+		if userInfo.Reminder != nil {
+			reminder.DaysBefore = userInfo.Reminder.DaysBefore
+		}
+		reminder.DueDay, _ = strconv.Atoi(day)
+		if daysBefore != "" {
+			reminder.DaysBefore, _ = strconv.Atoi(daysBefore)
+		}
+		userInfo.Reminder = &reminder
+	}
+
+	if err := h.storage.SaveUser(ctx, userID, userInfo); err != nil {
+		loggerFrom(ctx).errorf("Error while saving user: %v", err)
+		return replyWithMessage(upd, tr.text("error"))
+	}
+	return reminderMenu(upd, userInfo.Reminder, tr)
+}
+
+// reminderMenu offers due days while reminders are off and how early to remind when they are on
+func reminderMenu(upd telegram.Update, reminder *model.ReminderSettings, tr translator) telegram.ReplyMessage {
+	var text string
+	var row []telegram.InlineKeyboardButton
+	if reminder == nil {
+		text = tr.text("remind.disabled")
+		for _, day := range dueDays {
+			row = append(row, telegram.InlineKeyboardButton{
+				Text:         tr.text("remind.dueDayButton", day),
+				CallbackData: fmt.Sprintf("/remind %v", day),
+			})
+		}
+	} else {
+		text = tr.plural("remind.enabled", reminder.DaysBefore, reminder.DaysBefore, reminder.DueDay)
+		for _, days := range daysBeforeOptions {
+			row = append(row, telegram.InlineKeyboardButton{
+				Text:         tr.plural("remind.daysBeforeButton", days, days),
+				CallbackData: fmt.Sprintf("/remind %v %v", reminder.DueDay, days),
+			})
+		}
+		row = append(row, telegram.InlineKeyboardButton{
+			Text:         tr.text("remind.offButton"),
+			CallbackData: "/remind " + remindOff,
+		})
+	}
+	return telegram.ReplyMessage{
+		ChatId:      getReplyToChatID(upd),
+		Text:        text,
+		ReplyMarkup: telegram.InlineKeyboardMarkup{InlineKeyboard: [][]telegram.InlineKeyboardButton{row}},
+	}
+}
+
+func isDueDay(arg string) bool {
+	day, err := strconv.Atoi(arg)
+	return arg == remindOff || err == nil && day >= 1 && day <= 31
+}
+
+func isDaysBefore(arg string) bool {
+	days, err := strconv.Atoi(arg)
+	return err == nil && days >= 0 && days <= maxDaysBefore
+}
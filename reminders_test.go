@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestReminderDue(t *testing.T) {
+	settings := model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	cases := []struct {
+		today  string
+		due    string
+		remind bool
+	}{
+		{"2024-03-16", "2024-03-20", false},
+		{"2024-03-17", "2024-03-20", true},
+		{"2024-03-20", "2024-03-20", true},
+		{"2024-03-28", "2024-03-20", true},
+		{"2024-04-01", "2024-04-20", false},
+	}
+	for _, c := range cases {
+		due, remind := reminderDue(settings, parseDate(t, c.today))
+		if due.Format(dateLayout) != c.due || remind != c.remind {
+			t.Errorf("%v: expected %v %v, but got %v %v", c.today, c.due, c.remind, due.Format(dateLayout), remind)
+		}
+	}
+}
+
+func TestReminderDueAcrossMonths(t *testing.T) {
+	early := model.ReminderSettings{DueDay: 2, DaysBefore: 5}
+	if due, remind := reminderDue(early, parseDate(t, "2024-01-29")); !remind || due.Format(dateLayout) != "2024-02-02" {
+		t.Error("Expected reminder about February bill, but got ", due, remind)
+	}
+	late := model.ReminderSettings{DueDay: 31, DaysBefore: 0}
+	if due, remind := reminderDue(late, parseDate(t, "2024-02-29")); !remind || due.Format(dateLayout) != "2024-02-29" {
+		t.Error("Expected due day to be the last day of February, but got ", due, remind)
+	}
+}
+
+func TestNotifierRemindsOncePerDayUntilPaid(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 1500.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 1500), sender)
+	n.now = func() time.Time { return parseDate(t, "2024-01-18").Add(10 * time.Hour) }
+
+	n.runCycle(context.Background())
+	n.runCycle(context.Background())
+
+	if len(sender.messages) != 1 || !strings.Contains(sender.messages[0].Text, "20.01.2024") {
+		t.Fatal("Expected a reminder, but got ", sender.messages)
+	}
+	if remindedOn := storage.userInfo.Subscriptions["account_0"].RemindedOn; remindedOn != "2024-01-18" {
+		t.Error("Expected reminder date to be saved, but got ", remindedOn)
+	}
+
+	n.now = func() time.Time { return parseDate(t, "2024-01-21") }
+	n.runCycle(context.Background())
+	if len(sender.messages) != 2 || !strings.Contains(sender.messages[1].Text, "Срок оплаты 20.01.2024 прошел") {
+		t.Fatal("Expected overdue reminder, but got ", sender.messages)
+	}
+
+	n = createTestNotifier(storage, createBalanceClient("Январь", 0), sender)
+	n.now = func() time.Time { return parseDate(t, "2024-01-22") }
+	n.runCycle(context.Background())
+	for _, msg := range sender.messages[2:] {
+		if strings.Contains(msg.Text, "Срок оплаты") {
+			t.Error("Paid bill must not be reminded: ", msg.Text)
+		}
+	}
+}
+
+func TestNotifierSavesReminderWithBalanceInOneWrite(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 1000.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	var written []model.SubscriptionInfo
+	storage.onWrite = func(_ int, userInfo model.UserInfo) {
+		written = append(written, userInfo.Subscriptions["account_0"])
+	}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 1500), &fakeSender{})
+	n.now = func() time.Time { return parseDate(t, "2024-01-18") }
+
+	n.runCycle(context.Background())
+
+	if len(written) != 1 || written[0].RemindedOn != "2024-01-18" || written[0].LastSeen.Rows[0].Amount != 1500 {
+		t.Error("Expected one write with reminder date and new balance, but got ", written)
+	}
+}
+
+func TestNotifierDoesNotRemindWithoutSettings(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 1500.0)
+	sender := &fakeSender{}
+	n := createTestNotifier(createNotifierStorage(&snapshot), createBalanceClient("Январь", 1500), sender)
+	n.now = func() time.Time { return parseDate(t, "2024-01-20") }
+
+	n.runCycle(context.Background())
+
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierDoesNotRemindWithUnknownTotal(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Начислено", 1500.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	sender := &fakeSender{}
+	client := createFakeERCClient(1)
+	client.balance = &erclib.BalanceInfo{Month: "Январь", Rows: []erclib.BalanceRow{{Requisite: "Начислено", Amount: 1500}}}
+	n := createTestNotifier(storage, client, sender)
+	n.now = func() time.Time { return parseDate(t, "2024-01-20") }
+
+	n.runCycle(context.Background())
+
+	ensureNoMessages(t, sender)
+}
+
+func TestRemindCommandConfiguresReminder(t *testing.T) {
+	storage := createFakeStorage()
+	h := createAlertTestHandler(storage)
+
+	reply := h.handle(context.Background(), makeMsgUpdate("/remind"))
+	buttons := reply.(telegram.ReplyMessage).ReplyMarkup.(telegram.InlineKeyboardMarkup).InlineKeyboard
+	if len(buttons[0]) != len(dueDays) || buttons[0][0].CallbackData != "/remind 10" {
+		t.Error("Expected due day buttons, but got ", buttons)
+	}
+
+	h.handle(context.Background(), makeCallbackUpdate("/remind 25"))
+	if reminder := storage.userInfo.Reminder; reminder == nil || *reminder != (model.ReminderSettings{DueDay: 25, DaysBefore: 3}) {
+		t.Fatal("Unexpected reminder: ", reminder)
+	}
+	reply = h.handle(context.Background(), makeCallbackUpdate("/remind 25 7"))
+	if msg := reply.(telegram.ReplyMessage); !strings.Contains(msg.Text, "за 7 дней до 25 числа") {
+		t.Error("Unexpected reply: ", msg.Text)
+	}
+
+	h.handle(context.Background(), makeCallbackUpdate("/remind off"))
+	if storage.userInfo.Reminder != nil {
+		t.Error("Reminder must be turned off")
+	}
+}
+
+func TestRemindArgumentsAreValidated(t *testing.T) {
+	for _, text := range []string{"/remind 0", "/remind 32", "/remind 20 28", "/remind tomorrow"} {
+		if _, err := ParseCommand(text); err == nil {
+			t.Error("Expected error for ", text)
+		}
+	}
+}
+
+func parseDate(t *testing.T, date string) time.Time {
+	parsed, err := time.ParseInLocation(dateLayout, date, time.Local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
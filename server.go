@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minya/telegram"
+)
+
+type replySender interface {
+	messageSender
+	SendDocument(ctx context.Context, document telegram.ReplyDocument) error
+}
+
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// updatesServer receives updates pushed by Telegram webhook and sends handler's replies.
+// Unlike telegram.StartListen it can be shut down.
+// Requests to other paths or without secretToken (if it is set) never reach handler.
+type updatesServer struct {
+	handle      func(context.Context, telegram.Update) interface{}
+	sender      replySender
+	path        string
+	secretToken string
+}
+
+func (s updatesServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != s.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.isAuthorized(r) {
+		rootLogger.warnf("Rejected update from %v: wrong secret token", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var upd telegram.Update
+	if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+		rootLogger.warnf("Unable to decode update: %v", err)
+		http.Error(w, "Bad update", http.StatusBadRequest)
+		return
+	}
+	// the update is processed to the end even if Telegram drops the connection or shutdown begins
+	processUpdate(detach(r.Context()), s.handle, s.sender, upd)
+	io.WriteString(w, "ok")
+}
+
+// processUpdate handles update and sends reply, it's the same for webhook and polling
+func processUpdate(
+	ctx context.Context,
+	handle func(context.Context, telegram.Update) interface{},
+	sender replySender,
+	upd telegram.Update) {
+	ctx = withLogFields(ctx, "update", upd.UpdateId, "correlation", newCorrelationID())
+	sendReply(ctx, sender, handle(ctx, upd))
+}
+
+func (s updatesServer) isAuthorized(r *http.Request) bool {
+	if s.secretToken == "" {
+		return true
+	}
+	token := r.Header.Get(secretTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.secretToken)) == 1
+}
+
+func sendReply(ctx context.Context, sender replySender, reply interface{}) {
+	var err error
+	switch r := reply.(type) {
+	case telegram.ReplyMessage:
+		err = sender.SendMessage(ctx, r)
+	case telegram.ReplyDocument:
+		err = sender.SendDocument(ctx, r)
+	default:
+		return
+	}
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to send reply: %v", err)
+	}
+}
+
+// detachedContext keeps values of its parent but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+// detach returns ctx for work which must not be interrupted halfway, e.g. a check that saves its result.
+// Such work is bounded by the shutdown timeout instead.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minya/telegram"
+)
+
+func TestUpdatesServerSendsReply(t *testing.T) {
+	sender := &fakeReplySender{}
+	var makeClient = func(l string, p string) ercclient {
+		return createFakeERCClient(1)
+	}
+	h := createHandler(createFakeStorage(), newFakeHistory(), makeClient, &fakeBot{})
+	server := updatesServer{handle: h.handle, sender: sender, path: "/"}
+
+	body := `{"update_id":1,"message":{"message_id":1,"from":{"id":100500},"chat":{"id":404040},"text":"/help"}}`
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	if recorder.Code != http.StatusOK {
+		t.Error("Unexpected status: ", recorder.Code)
+	}
+	if len(sender.messages) != 1 || sender.messages[0].ChatId != chatID {
+		t.Error("Expected reply to be sent, but got ", sender.messages)
+	}
+}
+
+func TestUpdatesServerRejectsMalformedUpdate(t *testing.T) {
+	server := updatesServer{
+		handle: func(context.Context, telegram.Update) interface{} {
+			t.Error("Handler must not be called")
+			return nil
+		},
+		sender: &fakeReplySender{},
+		path:   "/",
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{")))
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Error("Unexpected status: ", recorder.Code)
+	}
+}
+
+func TestUpdatesServerChecksSecretToken(t *testing.T) {
+	cases := map[string]int{
+		"right_secret": http.StatusOK,
+		"wrong_secret": http.StatusUnauthorized,
+		"":             http.StatusUnauthorized,
+	}
+	for token, expected := range cases {
+		handled := false
+		server := updatesServer{
+			handle: func(context.Context, telegram.Update) interface{} {
+				handled = true
+				return nil
+			},
+			sender:      &fakeReplySender{},
+			path:        "/",
+			secretToken: "right_secret",
+		}
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`))
+		if token != "" {
+			request.Header.Set(secretTokenHeader, token)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+
+		if recorder.Code != expected {
+			t.Errorf("Token %q: expected %v, but got %v", token, expected, recorder.Code)
+		}
+		if handled != (expected == http.StatusOK) {
+			t.Errorf("Token %q: handler called: %v", token, handled)
+		}
+	}
+}
+
+func TestUpdatesServerAcceptsOnlyConfiguredPath(t *testing.T) {
+	server := updatesServer{
+		handle: func(context.Context, telegram.Update) interface{} {
+			t.Error("Handler must not be called")
+			return nil
+		},
+		sender: &fakeReplySender{},
+		path:   "/hook",
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"update_id":1}`)))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Error("Unexpected status: ", recorder.Code)
+	}
+}
+
+func TestWebhookSettingsValidation(t *testing.T) {
+	cases := map[WebhookSettings]bool{
+		{}:                           true,
+		{Port: 8443, Path: "/hook"}:  true,
+		{SecretToken: "Abc_123-xyz"}: true,
+		{Port: 70000}:                false,
+		{Path: "hook"}:               false,
+		{SecretToken: "with spaces"}: false,
+		{SecretToken: "кириллица_secret"}: false,
+	}
+	for settings, expected := range cases {
+		if settings.areValid() != expected {
+			t.Errorf("%+v: expected valid=%v", settings, expected)
+		}
+	}
+	if port := (WebhookSettings{}).port(); port != defaultWebhookPort {
+		t.Error("Expected default port, but got ", port)
+	}
+}
+
+func TestSendReplySendsDocument(t *testing.T) {
+	sender := &fakeReplySender{}
+	sendReply(context.Background(), sender, telegram.ReplyDocument{ChatId: chatID})
+	sendReply(context.Background(), sender, nil)
+
+	if len(sender.documents) != 1 || len(sender.messages) != 0 {
+		t.Error("Expected one document, but got ", sender.documents, sender.messages)
+	}
+}
+
+func TestDetachedContextIsNotCancelled(t *testing.T) {
+	type key struct{}
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	cancel()
+
+	ctx := detach(parent)
+
+	if ctx.Err() != nil {
+		t.Error("Detached context must not be cancelled")
+	}
+	if ctx.Value(key{}) != "value" {
+		t.Error("Detached context must keep parent's values")
+	}
+}
+
+type fakeReplySender struct {
+	fakeSender
+	documents []telegram.ReplyDocument
+}
+
+func (s *fakeReplySender) SendDocument(ctx context.Context, document telegram.ReplyDocument) error {
+	s.documents = append(s.documents, document)
+	return nil
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/minya/erc/erclib"
@@ -10,81 +10,293 @@ import (
 	"github.com/minya/telegram"
 )
 
+type messageSender interface {
+	SendMessage(ctx context.Context, msg telegram.ReplyMessage) error
+}
+
 type notifier struct {
-	sleepDuration time.Duration
-	storage       model.UserStorage
-	botToken      string
+	sleepDuration  time.Duration
+	storage        model.UserStorage
+	history        model.HistoryStorage
+	buildERCClient func(string, string) ercclient
+	sender         messageSender
+	concurrency    int
+	checkTimeout   time.Duration
+	backoff        backoffPolicy
+	now            func() time.Time
 }
 
-func (n notifier) Start(api *telegram.Api) {
-	go n.updateLoop(api)
+func createNotifier(
+	storage model.UserStorage,
+	history model.HistoryStorage,
+	buildERCClient func(string, string) ercclient,
+	sender messageSender,
+	sleepDuration time.Duration,
+	settings NotifierSettings) notifier {
+	return notifier{
+		sleepDuration:  sleepDuration,
+		storage:        storage,
+		history:        history,
+		buildERCClient: buildERCClient,
+		sender:         sender,
+		concurrency:    settings.concurrency(),
+		checkTimeout:   settings.checkTimeout(),
+		backoff: backoffPolicy{
+			base:              sleepDuration,
+			max:               settings.maxBackoff(),
+			authFailuresLimit: settings.authFailuresLimit(),
+		},
+		now: time.Now,
+	}
 }
 
-func (n notifier) updateLoop(api *telegram.Api) {
-	for true {
-		log.Printf("Update...\n")
-		subsMap, err := n.storage.GetUsers()
-		if err != nil {
-			log.Printf("Error: %v\n", err)
-		} else {
-			for id, userInfo := range subsMap {
-				log.Printf("[Update] Check user %v\n", id)
-				for accountNum, sub := range userInfo.Subscriptions {
-					ercClient := erclib.NewErcClientWithCredentials(userInfo.Login, userInfo.Password)
-					accounts, err := ercClient.GetAccounts()
-					if err != nil {
-						log.Printf("WARN  No accounts")
-						continue
-					}
-					account, err := findAccount(accounts, accountNum)
-					if err != nil {
-						log.Printf("WARN  No account %v among accounts", accountNum)
-						continue
-					}
-					n.compareAndNotify(api, id, account, sub, userInfo, ercClient)
-				}
+// Run checks users every sleepDuration until ctx is done.
+// On cancellation no more checks are started and Run returns once in-flight ones are finished.
+func (n notifier) Run(ctx context.Context) {
+	ctx = withLogFields(ctx, "component", "notifier")
+	for ctx.Err() == nil {
+		cycleStart := time.Now()
+		n.runCycle(ctx)
+		timer := time.NewTimer(n.sleepDuration - time.Since(cycleStart))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+	loggerFrom(ctx).infof("Stopped")
+}
+
+// runCycle checks every subscribed user once
+func (n notifier) runCycle(ctx context.Context) cycleStats {
+	loggerFrom(ctx).infof("Cycle started")
+	defer cycleDuration.observeSince(time.Now())
+	stats := &cycleStats{}
+	subsMap, err := n.storage.GetUsers(ctx)
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to get users: %v", err)
+		return *stats
+	}
+	scheduler := checkScheduler{concurrency: n.concurrency, spreadPeriod: n.sleepDuration}
+	scheduler.run(ctx, n.makeChecks(detach(ctx), subsMap, stats))
+	usersChecked.add(float64(atomic.LoadInt64(&stats.Users)))
+	notifierERCCalls.add(float64(stats.ERCCalls()))
+	notifierERCLogins.add(float64(atomic.LoadInt64(&stats.Logins)))
+	loggerFrom(ctx).infof("Cycle finished: %v", stats)
+	return *stats
+}
+
+// makeChecks makes a check per user, subscriptions of the same user are checked sequentially.
+// Checks use ctx which is not cancelled, so a started check saves its results during shutdown.
+func (n notifier) makeChecks(ctx context.Context, subsMap map[int]model.UserInfo, stats *cycleStats) []func() {
+	checks := make([]func(), 0, len(subsMap))
+	for id, userInfo := range subsMap {
+		if len(userInfo.Subscriptions) == 0 {
+			continue
+		}
+		id, userInfo := id, userInfo
+		checks = append(checks, func() {
+			atomic.AddInt64(&stats.Users, 1)
+			n.checkUser(withLogFields(ctx, "check", newCorrelationID(), "user", id), id, userInfo, stats)
+		})
+	}
+	return checks
+}
+
+// checkUser fetches accounts once and checks every subscribed account over the same client
+func (n notifier) checkUser(ctx context.Context, id int, userInfo model.UserInfo, stats *cycleStats) {
+	if health := userInfo.Health; health != nil {
+		if health.Paused {
+			loggerFrom(ctx).debugf("Checks are paused until /reg. Skip.")
+			return
+		}
+		if n.now().Before(health.NextAttempt) {
+			loggerFrom(ctx).debugf("Backs off until %v. Skip.", health.NextAttempt)
+			return
+		}
+	}
+
+	loggerFrom(ctx).debugf("Check user")
+	ercClient := countingERCClient{
+		client: withTimeout(n.buildERCClient(userInfo.Login, userInfo.Password), n.checkTimeout),
+		stats:  stats,
+	}
+	accounts, err := ercClient.GetAccounts()
+	if err != nil {
+		loggerFrom(ctx).warnf("No accounts: %v", err)
+		n.registerFailure(ctx, id, err)
+		return
+	}
+	if userInfo.Health != nil {
+		loggerFrom(ctx).infof("Recovered after %v failures", userInfo.Health.ConsecutiveFailures)
+		err = n.updateUser(ctx, id, func(stored *model.UserInfo) bool {
+			if stored.Health == nil {
+				return false
 			}
+			stored.Health = nil
+			return true
+		})
+		if err != nil {
+			loggerFrom(ctx).errorf("Unable to save check health: %v", err)
 		}
-		time.Sleep(n.sleepDuration)
+	}
+	for accountNum, sub := range userInfo.Subscriptions {
+		accountCtx := withLogFields(ctx, "account", accountNum, "chat", sub.ChatID)
+		account, err := findAccount(accounts, accountNum)
+		if err != nil {
+			loggerFrom(accountCtx).warnf("No such account among accounts")
+			continue
+		}
+		n.compareAndNotify(accountCtx, id, account, sub, userInfo, ercClient)
 	}
 }
 
 func (n notifier) compareAndNotify(
-	api *telegram.Api, userID int, account erclib.Account, sub model.SubscriptionInfo, userInfo model.UserInfo, ercClient erclib.ErcClient) {
+	ctx context.Context, userID int, account erclib.Account, sub model.SubscriptionInfo, userInfo model.UserInfo, ercClient ercclient) {
 
 	if sub.ChatID == 0 {
-		log.Printf("[Update] User %v is not subscribed. Skip.\n", userID)
+		loggerFrom(ctx).debugf("Not subscribed. Skip.")
 		return
 	}
-	balanceInfo, err := ercClient.GetBalanceInfo(account.Number, time.Now())
+	balanceInfo, err := ercClient.GetBalanceInfo(account.Number, n.now())
 	if err != nil {
-		log.Printf("[Update] Error: can't get balance for user %v\n", userID)
+		loggerFrom(ctx).warnf("Unable to get balance: %v", err)
 		return
 	}
-	newState := fmt.Sprintf("%v", balanceInfo)
-
-	if sub.LastSeenState == "" {
-		sub.LastSeenState = newState
-		userInfo.Subscriptions[account.Number] = sub
-		n.storage.SaveUser(userID, userInfo)
-		log.Printf("[Update] Initial balance correction for user %v\n", userID)
-	} else if sub.LastSeenState != newState {
-		log.Printf("[Update] Balance changed for user %v\n", userID)
-		sub.LastSeenState = newState
-		userInfo.Subscriptions[account.Number] = sub
-		n.storage.SaveUser(userID, userInfo)
-
-		messageText := "Баланс обновился:\n" + formatBalance(account, balanceInfo)
-		msg := telegram.ReplyMessage{
-			ChatId:      sub.ChatID,
-			Text:        messageText,
-			ReplyMarkup: replyButtons(),
+	newState := snapshotBalance(balanceInfo)
+	reminder, remindedOn, remind := n.dueReminder(ctx, account, sub, userInfo, newState)
+
+	// nothing is recorded nor sent unless the subscription is still there:
+	// the user may have logged out or unsubscribed during the check
+	var stillSubscribed bool
+	if sub.LastSeen == nil {
+		stillSubscribed = n.saveSubscription(ctx, userID, account.Number, func(stored *model.SubscriptionInfo) bool {
+			stored.LastSeen, stored.RemindedOn = &newState, remindedOn
+			return true
+		})
+		if stillSubscribed {
+			loggerFrom(ctx).infof("Initial balance correction")
+			n.recordAndRemind(ctx, userID, account, balanceInfo, reminder, remind)
 		}
-		err = api.SendMessage(msg)
-		if err != nil {
-			fmt.Printf("%v\n", err)
+		return
+	}
+
+	changed := !diffBalance(*sub.LastSeen, newState).IsEmpty()
+	quiet := inQuietHours(userInfo, n.now())
+	if !changed && (sub.Pending == nil || quiet) {
+		loggerFrom(ctx).debugf("Balance hasn't been changed")
+		stillSubscribed = n.saveSubscription(ctx, userID, account.Number, func(stored *model.SubscriptionInfo) bool {
+			if remindedOn == stored.RemindedOn {
+				return false
+			}
+			stored.RemindedOn = remindedOn
+			return true
+		})
+		if stillSubscribed {
+			n.recordAndRemind(ctx, userID, account, balanceInfo, reminder, remind)
 		}
-	} else {
-		log.Printf("[Update] Balance hasn't been changed\n")
+		return
+	}
+	if changed {
+		loggerFrom(ctx).infof("Balance changed")
+	}
+
+	// changes noticed during quiet hours are notified at once, against the balance notified last
+	previous := *sub.LastSeen
+	if sub.Pending != nil {
+		previous = *sub.Pending
+	}
+	var pending *model.BalanceSnapshot
+	if quiet {
+		pending = &previous
+	}
+	stillSubscribed = n.saveSubscription(ctx, userID, account.Number, func(stored *model.SubscriptionInfo) bool {
+		stored.LastSeen, stored.Pending, stored.RemindedOn = &newState, pending, remindedOn
+		return true
+	})
+	if !stillSubscribed {
+		return
+	}
+	n.recordAndRemind(ctx, userID, account, balanceInfo, reminder, remind)
+	if quiet {
+		loggerFrom(ctx).infof("Quiet hours. Notification is queued.")
+		notificationsSent.inc(outcomeQueued)
+		return
+	}
+
+	diff := diffBalance(previous, newState)
+	if diff.IsEmpty() {
+		loggerFrom(ctx).debugf("Queued changes cancelled each other out")
+		return
+	}
+	fired := firedAlerts(sub.Alerts, previous, newState)
+	if len(sub.Alerts) > 0 && len(fired) == 0 {
+		loggerFrom(ctx).debugf("No alert rule fired")
+		notificationsSent.inc(outcomeSuppressed)
+		return
+	}
+	tr := newTranslator(userInfo.Language)
+	msg := telegram.ReplyMessage{
+		ChatId:      sub.ChatID,
+		Text:        formatDiff(account, diff, balanceInfo, tr) + formatFiredAlerts(fired, previous, newState, tr),
+		ReplyMarkup: replyButtons(),
+	}
+	err = n.sender.SendMessage(ctx, msg)
+	notificationsSent.inc(outcomeOf(err))
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to send notification: %v", err)
+	}
+}
+
+// updateUser applies change to the user as stored now rather than as loaded at the start of the cycle,
+// so changes made by the user meanwhile survive. Nothing is written if change reports no changes
+// or the user has logged out.
+func (n notifier) updateUser(ctx context.Context, userID int, change func(stored *model.UserInfo) bool) error {
+	stored, err := n.storage.GetUserInfo(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if stored.Login == "" {
+		loggerFrom(ctx).infof("User has logged out during the check. Nothing is saved.")
+		return nil
+	}
+	if !change(&stored) {
+		return nil
+	}
+	return n.storage.SaveUser(ctx, userID, stored)
+}
+
+// saveSubscription applies change to the stored subscription unless user has unsubscribed meanwhile.
+// It reports whether the subscription is still there and its changes, if any, are saved.
+func (n notifier) saveSubscription(
+	ctx context.Context, userID int, accountNum string, change func(stored *model.SubscriptionInfo) bool) bool {
+	subscribed := false
+	err := n.updateUser(ctx, userID, func(stored *model.UserInfo) bool {
+		sub, ok := stored.Subscriptions[accountNum]
+		if !ok || sub.ChatID == 0 {
+			loggerFrom(ctx).infof("Unsubscribed during the check. Nothing is saved.")
+			return false
+		}
+		subscribed = true
+		if !change(&sub) {
+			return false
+		}
+		stored.Subscriptions[accountNum] = sub
+		return true
+	})
+	if err != nil {
+		loggerFrom(ctx).errorf("Unable to save subscription: %v", err)
+		return false
+	}
+	return subscribed
+}
+
+// recordAndRemind records checked balance and sends due reminder once the subscription is saved
+func (n notifier) recordAndRemind(
+	ctx context.Context, userID int, account erclib.Account, balanceInfo erclib.BalanceInfo,
+	reminder telegram.ReplyMessage, remind bool) {
+	recordBalance(ctx, n.history, userID, account.Number, balanceInfo, n.now())
+	if remind {
+		n.sendReminder(ctx, reminder)
 	}
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minya/erc/erclib"
+	"github.com/minya/ercInfoBot/model"
+	"github.com/minya/telegram"
+)
+
+func TestNotifierFirstSeenBalanceIsSavedSilently(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 100), sender)
+
+	n.runCycle(context.Background())
+
+	lastSeen := storage.userInfo.Subscriptions["account_0"].LastSeen
+	if lastSeen == nil || lastSeen.Month != "Январь" || lastSeen.Rows[0].Amount != 100 {
+		t.Error("Expected balance to be saved, but got ", lastSeen)
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierUnchangedBalanceIsNotReported(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	writes := 0
+	storage := createNotifierStorage(&snapshot)
+	storage.onWrite = func(int, model.UserInfo) { writes++ }
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 100), sender)
+
+	n.runCycle(context.Background())
+
+	if writes != 0 {
+		t.Error("User must not be written, but was written times: ", writes)
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierChangedBalanceIsReported(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 250), sender)
+
+	n.runCycle(context.Background())
+
+	if len(sender.messages) != 1 {
+		t.Fatal("Expected 1 message, but got ", len(sender.messages))
+	}
+	msg := sender.messages[0]
+	if msg.ChatId != chatID {
+		t.Error("Message chat mismatch: ", msg.ChatId)
+	}
+	if !strings.Contains(msg.Text, "Итого: 100 → 250 (+150.00)") {
+		t.Error("Unexpected message: ", msg.Text)
+	}
+	if storage.userInfo.Subscriptions["account_0"].LastSeen.Rows[0].Amount != 250 {
+		t.Error("New balance must be saved")
+	}
+}
+
+func TestNotifierSavesStateEvenIfSendFails(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	sender := &fakeSender{err: fmt.Errorf("403 from telegram API")}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 250), sender)
+
+	n.runCycle(context.Background())
+
+	if storage.userInfo.Subscriptions["account_0"].LastSeen.Rows[0].Amount != 250 {
+		t.Error("New balance must be saved")
+	}
+}
+
+func TestNotifierSkipsUnsubscribed(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	storage.userInfo.Subscriptions["account_0"] = model.SubscriptionInfo{ChatID: 0}
+	client := createBalanceClient("Январь", 250)
+	client.balanceErr = fmt.Errorf("GetBalanceInfo must not be called")
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, client, sender)
+
+	n.runCycle(context.Background())
+
+	if storage.userInfo.Subscriptions["account_0"].LastSeen != nil {
+		t.Error("State of unsubscribed account must not be saved")
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierSkipsUsersWithoutSubscriptions(t *testing.T) {
+	storage := createFakeStorage()
+	built := false
+	var makeClient = func(l string, p string) ercclient {
+		built = true
+		return createFakeERCClient(1)
+	}
+	n := createNotifier(storage, newFakeHistory(), makeClient, &fakeSender{}, 0, NotifierSettings{})
+
+	n.runCycle(context.Background())
+
+	if built {
+		t.Error("ERC must not be queried for users without subscriptions")
+	}
+}
+
+func TestNotifierErrors(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	var doTest = func(t *testing.T, name string, client fakeERCClient) {
+		storage := createNotifierStorage(&snapshot)
+		storage.onWrite = func(int, model.UserInfo) {
+			t.Errorf("%v: user must not be written", name)
+		}
+		sender := &fakeSender{}
+		n := createTestNotifier(storage, client, sender)
+
+		n.runCycle(context.Background())
+
+		ensureNoMessages(t, sender)
+	}
+
+	noAccounts := createBalanceClient("Январь", 250)
+	noAccounts.accountsErr = fmt.Errorf("Authentication error")
+	storage := createNotifierStorage(&snapshot)
+	sender := &fakeSender{}
+	createTestNotifier(storage, noAccounts, sender).runCycle(context.Background())
+	ensureNoMessages(t, sender)
+	if storage.userInfo.Subscriptions["account_0"].LastSeen.Rows[0].Amount != 100 {
+		t.Error("accounts error: balance must not be changed")
+	}
+	if health := storage.userInfo.Health; health == nil || health.Paused {
+		t.Error("accounts error: check must be backed off, but got ", health)
+	}
+
+	noBalance := createBalanceClient("Январь", 250)
+	noBalance.balance = nil
+	noBalance.balanceErr = fmt.Errorf("No match found")
+	doTest(t, "balance error", noBalance)
+
+	otherAccount := createBalanceClient("Январь", 250)
+	otherAccount.accounts[0].Number = "account_1"
+	doTest(t, "account not found", otherAccount)
+}
+
+func TestNotifierSkipsCycleIfUsersUnavailable(t *testing.T) {
+	storage := &failingUsersStorage{fakeStorage: createNotifierStorage(nil)}
+	sender := &fakeSender{}
+	n := createTestNotifier(storage, createBalanceClient("Январь", 250), sender)
+
+	n.runCycle(context.Background())
+
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierRecordsHistory(t *testing.T) {
+	history := newFakeHistory()
+	var makeClient = func(l string, p string) ercclient {
+		return createBalanceClient("Январь", 100)
+	}
+	n := createNotifier(createNotifierStorage(nil), history, makeClient, &fakeSender{}, 0, NotifierSettings{})
+
+	n.runCycle(context.Background())
+
+	if len(history.entries["account_0"]) != 1 {
+		t.Error("Expected balance to be recorded, but got ", history.entries)
+	}
+}
+
+func createTestNotifier(storage model.UserStorage, client fakeERCClient, sender messageSender) notifier {
+	var makeClient = func(l string, p string) ercclient {
+		return client
+	}
+	return createNotifier(storage, newFakeHistory(), makeClient, sender, 0, NotifierSettings{})
+}
+
+func createNotifierStorage(lastSeen *model.BalanceSnapshot) *fakeStorage {
+	storage := createFakeStorage()
+	storage.userInfo.Subscriptions = map[string]model.SubscriptionInfo{
+		"account_0": {ChatID: chatID, LastSeen: lastSeen},
+	}
+	return storage
+}
+
+func createBalanceClient(month string, total float64) fakeERCClient {
+	client := createFakeERCClient(1)
+	client.balance = &erclib.BalanceInfo{
+		Month: month,
+		Rows:  []erclib.BalanceRow{{Requisite: "Итого", Amount: total}},
+	}
+	return client
+}
+
+func ensureNoMessages(t *testing.T, sender *fakeSender) {
+	if len(sender.messages) != 0 {
+		t.Error("Expected no messages, but got ", sender.messages)
+	}
+}
+
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []telegram.ReplyMessage
+	err      error
+}
+
+func (s *fakeSender) SendMessage(ctx context.Context, msg telegram.ReplyMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return s.err
+}
+
+type failingUsersStorage struct {
+	*fakeStorage
+}
+
+func (s *failingUsersStorage) GetUsers(ctx context.Context) (map[int]model.UserInfo, error) {
+	return nil, fmt.Errorf("Unable to sign in")
+}
+
+func TestNotifierQueriesAccountsOncePerUser(t *testing.T) {
+	storage := createFakeStorage()
+	storage.userInfo.Subscriptions = map[string]model.SubscriptionInfo{
+		"account_0": {ChatID: chatID},
+		"account_1": {ChatID: chatID},
+		"account_2": {ChatID: chatID},
+	}
+	clientsBuilt, accountCalls := 0, 0
+	var makeClient = func(l string, p string) ercclient {
+		clientsBuilt++
+		return hookedERCClient{fakeERCClient: createFakeERCClient(3), beforeAccounts: func() { accountCalls++ }}
+	}
+	n := createNotifier(storage, newFakeHistory(), makeClient, &fakeSender{}, 0, NotifierSettings{})
+
+	stats := n.runCycle(context.Background())
+
+	// it used to be a client and an accounts request per subscription
+	if clientsBuilt != 1 || accountCalls != 1 {
+		t.Errorf("Expected 1 client and 1 accounts request per user, but got %v and %v", clientsBuilt, accountCalls)
+	}
+	if stats.Users != 1 || stats.AccountCalls != 1 || stats.BalanceCalls != 3 || stats.ERCCalls() != 4 || stats.Logins != 4 {
+		t.Error("Unexpected cycle stats: ", stats.String())
+	}
+}
+
+func TestNotifierRunFinishesStartedCheckOnCancel(t *testing.T) {
+	storage := createNotifierStorage(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	var makeClient = func(l string, p string) ercclient {
+		cancel()
+		return createBalanceClient("Январь", 100)
+	}
+	n := createNotifier(storage, newFakeHistory(), makeClient, &fakeSender{}, time.Hour, NotifierSettings{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.Run(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run must return after cancellation")
+	}
+	if storage.userInfo.Subscriptions["account_0"].LastSeen == nil {
+		t.Error("Started check must save its result")
+	}
+}
+
+func TestNotifierKeepsChangesMadeDuringCheck(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	client := hookedERCClient{fakeERCClient: createBalanceClient("Январь", 250), beforeBalance: func() {
+		// user changes language and subscribes to another account while the check is running
+		changed := storage.userInfo
+		changed.Language = langEN
+		changed.Subscriptions = map[string]model.SubscriptionInfo{
+			"account_0": storage.userInfo.Subscriptions["account_0"],
+			"account_1": {ChatID: chatID},
+		}
+		storage.userInfo = changed
+	}}
+	n := createNotifier(storage, newFakeHistory(), func(string, string) ercclient { return client },
+		&fakeSender{}, 0, NotifierSettings{})
+
+	n.runCycle(context.Background())
+
+	if storage.userInfo.Language != langEN || len(storage.userInfo.Subscriptions) != 2 {
+		t.Error("Changes made during check were lost: ", storage.userInfo)
+	}
+	if lastSeen := storage.userInfo.Subscriptions["account_0"].LastSeen; lastSeen == nil || lastSeen.Rows[0].Amount != 250 {
+		t.Error("New balance must be saved, but got ", lastSeen)
+	}
+}
+
+func TestNotifierDoesNotRestoreLoggedOutUser(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	client := hookedERCClient{fakeERCClient: createBalanceClient("Январь", 250), beforeBalance: func() {
+		storage.DeleteUser(context.Background(), userID)
+		storage.onWrite = func(int, model.UserInfo) {
+			t.Error("Logged out user must not be written")
+		}
+	}}
+	history, sender := newFakeHistory(), &fakeSender{}
+	n := createNotifier(storage, history, func(string, string) ercclient { return client }, sender, 0, NotifierSettings{})
+	n.now = func() time.Time { return parseDate(t, "2024-01-18") }
+
+	n.runCycle(context.Background())
+
+	if storage.userInfo.Login != "" {
+		t.Error("Logged out user is restored: ", storage.userInfo)
+	}
+	if len(history.entries) != 0 {
+		t.Error("History of logged out user must not be recorded, but got ", history.entries)
+	}
+	ensureNoMessages(t, sender)
+}
+
+func TestNotifierDoesNotNotifyUserUnsubscribedDuringCheck(t *testing.T) {
+	snapshot := makeSnapshot("Январь", "Итого", 100.0)
+	storage := createNotifierStorage(&snapshot)
+	storage.userInfo.Reminder = &model.ReminderSettings{DueDay: 20, DaysBefore: 3}
+	client := hookedERCClient{fakeERCClient: createBalanceClient("Январь", 250), beforeBalance: func() {
+		storage.userInfo.Subscriptions["account_0"] = model.SubscriptionInfo{}
+	}}
+	history, sender := newFakeHistory(), &fakeSender{}
+	n := createNotifier(storage, history, func(string, string) ercclient { return client }, sender, 0, NotifierSettings{})
+	n.now = func() time.Time { return parseDate(t, "2024-01-18") }
+
+	n.runCycle(context.Background())
+
+	if sub := storage.userInfo.Subscriptions["account_0"]; sub.ChatID != 0 || sub.LastSeen != nil {
+		t.Error("Unsubscribed account must not be written: ", sub)
+	}
+	if len(history.entries) != 0 {
+		t.Error("History must not be recorded, but got ", history.entries)
+	}
+	ensureNoMessages(t, sender)
+}
+
+// hookedERCClient lets tests observe calls or change storage while a check is running
+type hookedERCClient struct {
+	fakeERCClient
+	beforeAccounts func()
+	beforeBalance  func()
+}
+
+func (c hookedERCClient) GetAccounts() ([]erclib.Account, error) {
+	if c.beforeAccounts != nil {
+		c.beforeAccounts()
+	}
+	return c.fakeERCClient.GetAccounts()
+}
+
+func (c hookedERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	if c.beforeBalance != nil {
+		c.beforeBalance()
+	}
+	return c.fakeERCClient.GetBalanceInfo(account, t)
+}
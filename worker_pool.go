@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/minya/erc/erclib"
+)
+
+var errCallTimeout = errors.New("ERC call timed out")
+
+// checkScheduler runs checks on a bounded number of workers.
+// Check starts are spread evenly across spreadPeriod instead of bursting all at once.
+type checkScheduler struct {
+	concurrency  int
+	spreadPeriod time.Duration
+}
+
+// run blocks until every started check is finished.
+// Once ctx is done remaining checks are not started.
+func (s checkScheduler) run(ctx context.Context, checks []func()) {
+	concurrency := s.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobs := make(chan func())
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range jobs {
+				check()
+			}
+		}()
+	}
+
+	start := time.Now()
+dispatch:
+	for i, check := range checks {
+		if s.spreadPeriod > 0 {
+			startAt := start.Add(s.spreadPeriod * time.Duration(i) / time.Duration(len(checks)))
+			timer := time.NewTimer(time.Until(startAt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				break dispatch
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case jobs <- check:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// timeoutERCClient limits duration of every ERC call.
+// erclib calls can't be cancelled, so a timed out call keeps running in background until it returns.
+type timeoutERCClient struct {
+	client  ercclient
+	timeout time.Duration
+}
+
+func withTimeout(client ercclient, timeout time.Duration) ercclient {
+	if timeout <= 0 {
+		return client
+	}
+	return timeoutERCClient{client: client, timeout: timeout}
+}
+
+func (c timeoutERCClient) GetAccounts() ([]erclib.Account, error) {
+	return callWithTimeout(c.timeout, c.client.GetAccounts)
+}
+
+func (c timeoutERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	return callWithTimeout(c.timeout, func() (erclib.BalanceInfo, error) {
+		return c.client.GetBalanceInfo(account, t)
+	})
+}
+
+func (c timeoutERCClient) GetReceipt(accNumber string) ([]byte, error) {
+	return callWithTimeout(c.timeout, func() ([]byte, error) {
+		return c.client.GetReceipt(accNumber)
+	})
+}
+
+func callWithTimeout[T any](timeout time.Duration, f func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := f()
+		done <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		var zero T
+		return zero, errCallTimeout
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minya/erc/erclib"
+)
+
+func TestSchedulerRunsAllChecksWithBoundedConcurrency(t *testing.T) {
+	client := &slowERCClient{fakeERCClient: createFakeERCClient(1), latency: 20 * time.Millisecond}
+	scheduler := checkScheduler{concurrency: 3}
+
+	checks := make([]func(), 10)
+	for i := range checks {
+		checks[i] = func() { client.GetAccounts() }
+	}
+	scheduler.run(context.Background(), checks)
+
+	if client.calls != 10 {
+		t.Error("Expected 10 calls, but got ", client.calls)
+	}
+	if client.maxInFlight > 3 {
+		t.Error("Expected at most 3 concurrent calls, but got ", client.maxInFlight)
+	}
+	if client.maxInFlight < 2 {
+		t.Error("Expected checks to run concurrently, but max in flight is ", client.maxInFlight)
+	}
+}
+
+func TestSchedulerSpreadsChecksAcrossPeriod(t *testing.T) {
+	scheduler := checkScheduler{concurrency: 5, spreadPeriod: 100 * time.Millisecond}
+	var mu sync.Mutex
+	var starts []time.Duration
+	begin := time.Now()
+
+	checks := make([]func(), 5)
+	for i := range checks {
+		checks[i] = func() {
+			mu.Lock()
+			defer mu.Unlock()
+			starts = append(starts, time.Since(begin))
+		}
+	}
+	scheduler.run(context.Background(), checks)
+
+	if len(starts) != 5 {
+		t.Fatal("Expected 5 checks, but got ", len(starts))
+	}
+	for i, start := range starts {
+		if expected := time.Duration(i) * 20 * time.Millisecond; start < expected {
+			t.Errorf("Check #%v started at %v, expected not before %v", i, start, expected)
+		}
+	}
+}
+
+func TestSchedulerStopsStartingChecksOnCancel(t *testing.T) {
+	scheduler := checkScheduler{concurrency: 1, spreadPeriod: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := 0
+
+	begin := time.Now()
+	scheduler.run(ctx, []func(){
+		func() { started++; cancel() },
+		func() { started++ },
+	})
+
+	if started != 1 {
+		t.Error("Expected only the first check to start, but started ", started)
+	}
+	if elapsed := time.Since(begin); elapsed >= time.Second/2 {
+		t.Error("Scheduler kept waiting after cancellation: ", elapsed)
+	}
+}
+
+func TestSlowUserDoesNotDelayOthers(t *testing.T) {
+	slow := &slowERCClient{fakeERCClient: createFakeERCClient(1), latency: 200 * time.Millisecond}
+	fast := &slowERCClient{fakeERCClient: createFakeERCClient(1)}
+	scheduler := checkScheduler{concurrency: 2}
+
+	var fastDone time.Duration
+	begin := time.Now()
+	scheduler.run(context.Background(), []func(){
+		func() { slow.GetAccounts() },
+		func() { fast.GetAccounts(); fast.GetAccounts(); fastDone = time.Since(begin) },
+	})
+
+	if fastDone >= 200*time.Millisecond {
+		t.Error("Fast user waited for slow one: ", fastDone)
+	}
+}
+
+func TestTimeoutERCClientLimitsSlowCalls(t *testing.T) {
+	slow := &slowERCClient{fakeERCClient: createFakeERCClient(1), latency: 500 * time.Millisecond}
+	client := withTimeout(slow, 20*time.Millisecond)
+
+	begin := time.Now()
+	_, errAccounts := client.GetAccounts()
+	_, errBalance := client.GetBalanceInfo("account_0", time.Now())
+	_, errReceipt := client.GetReceipt("account_0")
+
+	if errAccounts != errCallTimeout || errBalance != errCallTimeout || errReceipt != errCallTimeout {
+		t.Error("Expected timeouts, but got ", errAccounts, errBalance, errReceipt)
+	}
+	if elapsed := time.Since(begin); elapsed > 300*time.Millisecond {
+		t.Error("Calls were not interrupted: ", elapsed)
+	}
+}
+
+func TestTimeoutERCClientPassesFastCalls(t *testing.T) {
+	client := withTimeout(&slowERCClient{fakeERCClient: createFakeERCClient(2)}, time.Second)
+	accounts, err := client.GetAccounts()
+	if err != nil || len(accounts) != 2 {
+		t.Error("Expected 2 accounts, but got ", accounts, err)
+	}
+}
+
+// slowERCClient simulates ERC latency and tracks concurrent calls
+type slowERCClient struct {
+	fakeERCClient
+	latency     time.Duration
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	calls       int
+}
+
+func (c *slowERCClient) GetAccounts() ([]erclib.Account, error) {
+	c.enter()
+	defer c.leave()
+	return c.fakeERCClient.GetAccounts()
+}
+
+func (c *slowERCClient) GetBalanceInfo(account string, t time.Time) (erclib.BalanceInfo, error) {
+	c.enter()
+	defer c.leave()
+	return c.fakeERCClient.GetBalanceInfo(account, t)
+}
+
+func (c *slowERCClient) GetReceipt(accNumber string) ([]byte, error) {
+	c.enter()
+	defer c.leave()
+	return c.fakeERCClient.GetReceipt(accNumber)
+}
+
+func (c *slowERCClient) enter() {
+	c.mu.Lock()
+	c.calls++
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+	time.Sleep(c.latency)
+}
+
+func (c *slowERCClient) leave() {
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+}